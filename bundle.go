@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+
+	"mydocker/cgroups/subsystems"
+	"mydocker/constant"
+	"mydocker/container"
+	"mydocker/oci"
+	"mydocker/seccomp"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// ociNamespaces OCI namespace 类型到 clone flag 的映射
+var ociNamespaces = map[string]uintptr{
+	oci.PIDNamespace:     syscall.CLONE_NEWPID,
+	oci.NetworkNamespace: syscall.CLONE_NEWNET,
+	oci.MountNamespace:   syscall.CLONE_NEWNS,
+	oci.IPCNamespace:     syscall.CLONE_NEWIPC,
+	oci.UTSNamespace:     syscall.CLONE_NEWUTS,
+	oci.CgroupNamespace:  syscall.CLONE_NEWCGROUP,
+}
+
+// writeSpec 在 bundle 目录下生成默认的 config.json
+func writeSpec(bundle string) error {
+	configPath := filepath.Join(bundle, oci.SpecConfig)
+	if _, err := os.Stat(configPath); err == nil {
+		return fmt.Errorf("file %s exists, remove it first", configPath)
+	}
+	content, err := json.MarshalIndent(oci.Example(), "", "\t")
+	if err != nil {
+		return errors.Wrap(err, "marshal spec")
+	}
+	return os.WriteFile(configPath, content, constant.Perm0644)
+}
+
+// loadBundle 读取 OCI bundle，将 config.json 中的配置映射到 RunOptions 上
+/*
+	process、mounts、hostname 会转换为 InitSpec 通过 init 管道发给容器，
+	namespaces 转换为 clone flag，linux.resources 转换为 cgroup 的 ResourceConfig，
+	hooks 则在 Run 的各个阶段执行。
+*/
+func loadBundle(bundle string, opts *RunOptions) error {
+	bundle, err := filepath.Abs(bundle)
+	if err != nil {
+		return errors.Wrapf(err, "get abs path of %s", bundle)
+	}
+	spec, err := oci.LoadSpec(bundle)
+	if err != nil {
+		return err
+	}
+	cloneflags, namespaces, userns, err := cloneflagsFromOCI(spec.Linux)
+	if err != nil {
+		return err
+	}
+	profile, err := seccomp.FromOCI(spec.Linux.Seccomp)
+	if err != nil {
+		return err
+	}
+	rootfs := spec.RootfsPath(bundle)
+	opts.Bundle = bundle
+	opts.Rootfs = rootfs
+	opts.TTY = spec.Process.Terminal
+	// 有终端时和 -it 一样把标准输入传给容器
+	opts.Interactive = spec.Process.Terminal
+	opts.CmdList = spec.Process.Args
+	opts.Init = container.NewInitSpecFromOCI(spec, rootfs)
+	opts.Resource = resourceFromOCI(spec.Linux)
+	opts.Cloneflags = cloneflags
+	opts.Namespaces = namespaces
+	opts.UserNS = userns
+	opts.Seccomp = profile
+	opts.Hooks = spec.Hooks
+	opts.CgroupPath = spec.Linux.CgroupsPath
+	return nil
+}
+
+// cloneflagsFromOCI 将 namespaces 转换为 clone flag，user namespace 单独返回，id 映射使用 /etc/subuid 中的配置
+/*
+	指定了 path 的 namespace 不需要创建，而是在启动容器进程时加入，mount、user 和 cgroup namespace 不支持加入。
+*/
+func cloneflagsFromOCI(linux *oci.Linux) (cloneflags uintptr, namespaces []container.NamespacePath, userns bool, err error) {
+	if linux == nil {
+		return 0, nil, false, errors.New("linux section is required in config.json")
+	}
+	for _, ns := range linux.Namespaces {
+		if ns.Path != "" {
+			flag := ociNamespaces[ns.Type]
+			if flag == 0 || flag == syscall.CLONE_NEWNS || flag == syscall.CLONE_NEWCGROUP {
+				return 0, nil, false, fmt.Errorf("joining existing %s namespace is not supported", ns.Type)
+			}
+			namespaces = append(namespaces, container.NamespacePath{Flag: flag, Path: ns.Path})
+			continue
+		}
+		if ns.Type == oci.UserNamespace {
+			userns = true
+			continue
+		}
+		flag, ok := ociNamespaces[ns.Type]
+		if !ok {
+			return 0, nil, false, fmt.Errorf("namespace %s is not supported", ns.Type)
+		}
+		cloneflags |= flag
+	}
+	// init 需要在自己的 mount namespace 中挂载 rootfs 并 pivot_root
+	if cloneflags&syscall.CLONE_NEWNS == 0 {
+		return 0, nil, false, errors.New("mount namespace is required")
+	}
+	return cloneflags, namespaces, userns, nil
+}
+
+func resourceFromOCI(linux *oci.Linux) *subsystems.ResourceConfig {
+	cfg := &subsystems.ResourceConfig{}
+	if linux == nil || linux.Resources == nil {
+		return cfg
+	}
+	if cpu := linux.Resources.CPU; cpu != nil {
+		if cpu.Shares != nil {
+			cfg.CpuShare = strconv.FormatUint(*cpu.Shares, 10)
+		}
+		// ResourceConfig 中的 CpuCfsQuota 是百分比，需要根据 period 换算
+		if cpu.Quota != nil && *cpu.Quota > 0 {
+			period := uint64(subsystems.PeriodDefault)
+			if cpu.Period != nil && *cpu.Period > 0 {
+				period = *cpu.Period
+			}
+			cfg.CpuCfsQuota = int(uint64(*cpu.Quota) * subsystems.Percent / period)
+			// 百分比只能表示默认 period 下整数个百分点的 quota，换算不精确时提示实际使用的值
+			if cfg.CpuCfsQuota == 0 {
+				log.Warnf("cpu quota %d with period %d is less than 1%% of a cpu and is ignored", *cpu.Quota, period)
+			} else if uint64(*cpu.Quota)*subsystems.Percent%period != 0 || period != subsystems.PeriodDefault {
+				log.Warnf("cpu quota %d with period %d is converted to %d%% of a cpu with period %d",
+					*cpu.Quota, period, cfg.CpuCfsQuota, subsystems.PeriodDefault)
+			}
+		}
+		cfg.CpuSet = cpu.Cpus
+	}
+	if mem := linux.Resources.Memory; mem != nil && mem.Limit != nil {
+		cfg.MemoryLimit = strconv.FormatInt(*mem.Limit, 10)
+	}
+	if pids := linux.Resources.Pids; pids != nil {
+		cfg.PidsLimit = pids.Limit
+		// OCI 中 0 和负数都表示不限制，ResourceConfig 中 0 表示不设置
+		if cfg.PidsLimit == 0 {
+			cfg.PidsLimit = -1
+		}
+	}
+	if blkio := linux.Resources.BlockIO; blkio != nil {
+		if blkio.Weight != nil {
+			cfg.BlkioWeight = *blkio.Weight
+		}
+		cfg.ReadBpsDevice = throttleRules(blkio.ThrottleReadBpsDevice)
+		cfg.WriteBpsDevice = throttleRules(blkio.ThrottleWriteBpsDevice)
+		cfg.ReadIOpsDevice = throttleRules(blkio.ThrottleReadIOPSDevice)
+		cfg.WriteIOpsDevice = throttleRules(blkio.ThrottleWriteIOPSDevice)
+	}
+	return cfg
+}
+
+// throttleRules 把 OCI 中的设备限速转换为 ResourceConfig 中 major:minor rate 格式的规则
+func throttleRules(devices []oci.LinuxThrottleDevice) []string {
+	var rules []string
+	for _, d := range devices {
+		rules = append(rules, fmt.Sprintf("%d:%d %d", d.Major, d.Minor, d.Rate))
+	}
+	return rules
+}
@@ -1,47 +1,98 @@
 package cgroups
 
 import (
+	"fmt"
+	"strings"
+
 	"mydocker/cgroups/subsystems"
 
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
 
-// Path 为创建的 cgroup 相对于 root cgroup 的路径
-// Resource 则为需要初始化的配置
-type CgroupManager struct {
-	Path     string
-	Resource *subsystems.ResourceConfig
+// CgroupManager 管理一个容器的 cgroup
+type CgroupManager interface {
+	// 设置和创建 cgroup
+	Set(cfg *subsystems.ResourceConfig) error
+	// 将进程加入到 cgroup 中
+	Apply(pid int, cfg *subsystems.ResourceConfig) error
+	// 删除释放 cgroup
+	Destroy() error
+	// 读取 cgroup 的资源使用情况
+	GetStats() (*subsystems.Stats, error)
 }
 
-func NewCgroupManager(path string) *CgroupManager {
-	return &CgroupManager{
+// NewCgroupManager 根据宿主机的 cgroup 版本创建对应的 CgroupManager，path 为 cgroup 相对于根节点的路径
+func NewCgroupManager(path string) CgroupManager {
+	if IsCgroup2UnifiedMode() {
+		return NewCgroupManagerV2(path)
+	}
+	return &CgroupManagerV1{
 		Path: path,
 	}
 }
 
-// 将进程加入到 cgroup 中
-func (c *CgroupManager) Apply(pid int, cfg *subsystems.ResourceConfig) error {
+// CgroupManagerV1 管理 cgroup v1 中各个 subsystem 下的 cgroup
+// Path 为创建的 cgroup 相对于 root cgroup 的路径
+// Resource 则为需要初始化的配置
+type CgroupManagerV1 struct {
+	Path     string
+	Resource *subsystems.ResourceConfig
+}
+
+// 将进程加入到 cgroup 中，一个 subsystem 失败时继续加入其他的，最后返回所有失败的 subsystem
+func (c *CgroupManagerV1) Apply(pid int, cfg *subsystems.ResourceConfig) error {
+	var errs []string
 	// 将 pid 加入到已有的每个子系统中
 	for _, subsysIns := range subsystems.SubsystemInts {
 		if err := subsysIns.Apply(c.Path, pid, cfg); err != nil {
 			logrus.Errorf("apply subsystem: %s, err: %s", subsysIns.Name(), err)
+			errs = append(errs, fmt.Sprintf("%s: %v", subsysIns.Name(), err))
 		}
 	}
-	return nil
+	if len(errs) == 0 {
+		return nil
+	}
+	return errors.Errorf("apply cgroup failed: %s", strings.Join(errs, "; "))
 }
 
-// 设置和创建 cgroup
-func (c *CgroupManager) Set(cfg *subsystems.ResourceConfig) error {
+// 设置和创建 cgroup，一个 subsystem 失败时继续设置其他的，最后返回所有失败的 subsystem
+func (c *CgroupManagerV1) Set(cfg *subsystems.ResourceConfig) error {
+	var errs []string
 	for _, subsysIns := range subsystems.SubsystemInts {
 		if err := subsysIns.Set(c.Path, cfg); err != nil {
 			logrus.Errorf("apply subsystem: %s, err: %s", subsysIns.Name(), err)
+			errs = append(errs, fmt.Sprintf("%s: %v", subsysIns.Name(), err))
 		}
 	}
-	return nil
+	return joinSetErrors(errs)
+}
+
+// 读取各个 subsystem 中的资源使用情况
+func (c *CgroupManagerV1) GetStats() (*subsystems.Stats, error) {
+	stats := &subsystems.Stats{}
+	for _, subsysIns := range subsystems.SubsystemInts {
+		getter, ok := subsysIns.(subsystems.StatsGetter)
+		if !ok {
+			continue
+		}
+		if err := getter.GetStats(c.Path, stats); err != nil {
+			return nil, errors.Wrapf(err, "get %s stats", subsysIns.Name())
+		}
+	}
+	return stats, nil
+}
+
+// joinSetErrors 把设置 cgroup 时的多个错误合并为一个
+func joinSetErrors(errs []string) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return errors.Errorf("set cgroup failed: %s", strings.Join(errs, "; "))
 }
 
 // 删除释放 cgroup
-func (c *CgroupManager) Destroy() error {
+func (c *CgroupManagerV1) Destroy() error {
 	for _, subsysIns := range subsystems.SubsystemInts {
 		if err := subsysIns.Remove(c.Path); err != nil {
 			logrus.Warnf("remove cgroup failed %v", err)
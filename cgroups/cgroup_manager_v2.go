@@ -0,0 +1,252 @@
+package cgroups
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"mydocker/cgroups/subsystems"
+	"mydocker/constant"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// unifiedMountpoint 只使用 cgroup v2 的宿主机上 cgroup2 文件系统的挂载点
+const unifiedMountpoint = "/sys/fs/cgroup"
+
+// v2Controllers 需要在上层节点的 cgroup.subtree_control 中启用的 controller
+var v2Controllers = []string{"cpu", "cpuset", "io", "memory", "pids"}
+
+var (
+	unifiedOnce sync.Once
+	unified     bool
+)
+
+// IsCgroup2UnifiedMode 判断宿主机是否只使用 cgroup v2，也就是 /sys/fs/cgroup 本身就是 cgroup2 文件系统
+/*
+	v1 和 v2 混合挂载时，controller 都在 v1 的 hierarchy 中，/sys/fs/cgroup/unified 下的 cgroup2 只用来管理进程，
+	这种情况仍然使用 v1。
+*/
+func IsCgroup2UnifiedMode() bool {
+	unifiedOnce.Do(func() {
+		var st unix.Statfs_t
+		unified = unix.Statfs(unifiedMountpoint, &st) == nil && st.Type == unix.CGROUP2_SUPER_MAGIC
+	})
+	return unified
+}
+
+// CgroupManagerV2 管理 cgroup v2 统一层级中的 cgroup
+/*
+	v2 中所有 controller 共用一棵树，每个节点的 controller 要先在父节点的 cgroup.subtree_control 中启用，
+	对应的 cpu.max、memory.max 这些文件才会出现。
+*/
+type CgroupManagerV2 struct {
+	Path string // cgroup 相对于根节点的路径
+	root string // 根节点，非 root 用户为委派给自己的 cgroup
+}
+
+func NewCgroupManagerV2(path string) *CgroupManagerV2 {
+	root := unifiedMountpoint
+	// 非 root 用户只能使用委派给自己的 cgroup，也就是当前进程所在 cgroup 的子节点
+	if os.Geteuid() != 0 {
+		root = filepath.Join(root, findOwnCgroupV2())
+	}
+	return &CgroupManagerV2{
+		Path: path,
+		root: root,
+	}
+}
+
+// 设置和创建 cgroup
+func (c *CgroupManagerV2) Set(cfg *subsystems.ResourceConfig) error {
+	dir := c.dir()
+	if err := os.MkdirAll(dir, constant.Perm0755); err != nil {
+		return errors.Wrapf(err, "create cgroup %s", dir)
+	}
+	if err := c.enableControllers(); err != nil {
+		logrus.Errorf("enable cgroup controllers err: %s", err)
+	}
+	files, err := v2Files(cfg)
+	if err != nil {
+		return err
+	}
+	// 一个文件写入失败时继续写其他的，最后返回所有失败的文件
+	var errs []string
+	for _, f := range files {
+		if err = os.WriteFile(filepath.Join(dir, f[0]), []byte(f[1]), constant.Perm0644); err != nil {
+			logrus.Errorf("set cgroup %s err: %s", f[0], err)
+			errs = append(errs, fmt.Sprintf("%s: %v", f[0], err))
+		}
+	}
+	if cfg.BlkioWeight != 0 {
+		if err = setIOWeight(dir, cfg.BlkioWeight); err != nil {
+			logrus.Errorf("set cgroup io weight err: %s", err)
+			errs = append(errs, err.Error())
+		}
+	}
+	// v2 需要通过 eBPF 程序控制设备访问，还没有实现，CAP_MKNOD 的处理见 run 命令的 dropMknod
+	if cfg.Devices != nil {
+		logrus.Warnf("device cgroup rules are not supported on cgroup v2 and are ignored")
+	}
+	return joinSetErrors(errs)
+}
+
+// 将进程加入到 cgroup 中，v2 中进程只需要加入一个节点
+func (c *CgroupManagerV2) Apply(pid int, _ *subsystems.ResourceConfig) error {
+	procs := filepath.Join(c.dir(), "cgroup.procs")
+	err := os.WriteFile(procs, []byte(strconv.Itoa(pid)), constant.Perm0644)
+	return errors.Wrapf(err, "add process %d to cgroup", pid)
+}
+
+// 删除释放 cgroup，v2 中的目录只能用 rmdir 删除，其中的文件不能单独删除
+func (c *CgroupManagerV2) Destroy() error {
+	if err := os.Remove(c.dir()); err != nil && !os.IsNotExist(err) {
+		logrus.Warnf("remove cgroup failed %v", err)
+	}
+	return nil
+}
+
+// 读取 cgroup 的资源使用情况，v2 中所有文件都在同一个目录下
+func (c *CgroupManagerV2) GetStats() (*subsystems.Stats, error) {
+	dir := c.dir()
+	stats := &subsystems.Stats{HasMemory: true, HasPids: true}
+	values := []struct {
+		file  string
+		value *uint64
+	}{
+		{"memory.current", &stats.MemoryUsage},
+		{"memory.max", &stats.MemoryLimit},
+		{"pids.current", &stats.PidsCurrent},
+		{"pids.max", &stats.PidsLimit},
+	}
+	for _, v := range values {
+		n, err := subsystems.ReadCgroupUint(filepath.Join(dir, v.file))
+		if err != nil {
+			return nil, err
+		}
+		*v.value = n
+	}
+	return stats, nil
+}
+
+func (c *CgroupManagerV2) dir() string {
+	return filepath.Join(c.root, c.Path)
+}
+
+// enableControllers 从根节点开始，在容器 cgroup 的每一层上层节点中启用 v2Controllers
+/*
+	只启用上层节点的 cgroup.controllers 中有的 controller。有进程的节点不能再启用 controller，
+	非 root 用户自己所在的 cgroup 一般就是这样，这时只打印错误，容器仍然会加入 cgroup。
+*/
+func (c *CgroupManagerV2) enableControllers() error {
+	dir := c.root
+	for _, name := range strings.Split(strings.Trim(filepath.Clean(c.Path), "/"), "/") {
+		content, err := os.ReadFile(filepath.Join(dir, "cgroup.controllers"))
+		if err != nil {
+			return errors.Wrapf(err, "read %s/cgroup.controllers", dir)
+		}
+		available := strings.Fields(string(content))
+		var enable []string
+		for _, controller := range v2Controllers {
+			for _, a := range available {
+				if a == controller {
+					enable = append(enable, "+"+controller)
+				}
+			}
+		}
+		if len(enable) > 0 {
+			subtree := filepath.Join(dir, "cgroup.subtree_control")
+			if err = os.WriteFile(subtree, []byte(strings.Join(enable, " ")), constant.Perm0644); err != nil {
+				return errors.Wrapf(err, "write %s", subtree)
+			}
+		}
+		dir = filepath.Join(dir, name)
+	}
+	return nil
+}
+
+// v2Files 把 ResourceConfig 转换为 v2 中的文件名和内容
+func v2Files(cfg *subsystems.ResourceConfig) ([][2]string, error) {
+	var files [][2]string
+	if cfg.CpuShare != "" {
+		shares, err := strconv.ParseUint(cfg.CpuShare, 10, 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid cpu share %s", cfg.CpuShare)
+		}
+		files = append(files, [2]string{"cpu.weight", strconv.FormatUint(cpuSharesToWeight(shares), 10)})
+	}
+	if cfg.CpuCfsQuota != 0 {
+		quota := subsystems.PeriodDefault / subsystems.Percent * cfg.CpuCfsQuota
+		files = append(files, [2]string{"cpu.max", fmt.Sprintf("%d %d", quota, subsystems.PeriodDefault)})
+	}
+	if cfg.CpuSet != "" {
+		files = append(files, [2]string{"cpuset.cpus", cfg.CpuSet})
+	}
+	if cfg.MemoryLimit != "" {
+		files = append(files, [2]string{"memory.max", cfg.MemoryLimit})
+	}
+	if cfg.PidsLimit != 0 {
+		files = append(files, [2]string{"pids.max", subsystems.PidsMax(cfg.PidsLimit)})
+	}
+	// v1 的 major:minor rate 规则在 io.max 中写为 major:minor rbps=rate，每个规则单独写入
+	throttles := []struct {
+		key   string
+		rules []string
+	}{
+		{"rbps", cfg.ReadBpsDevice},
+		{"wbps", cfg.WriteBpsDevice},
+		{"riops", cfg.ReadIOpsDevice},
+		{"wiops", cfg.WriteIOpsDevice},
+	}
+	for _, t := range throttles {
+		for _, rule := range t.rules {
+			device, rate, ok := strings.Cut(rule, " ")
+			if !ok {
+				return nil, errors.Errorf("invalid device rate %s", rule)
+			}
+			files = append(files, [2]string{"io.max", fmt.Sprintf("%s %s=%s", device, t.key, rate)})
+		}
+	}
+	return files, nil
+}
+
+// setIOWeight 设置 I/O 权重，使用 BFQ 调度器时写入 io.bfq.weight，取值范围和 v1 相同，
+// 否则按 runc 的方式把 10 到 1000 换算为 io.weight 的 1 到 10000
+func setIOWeight(dir string, weight uint16) error {
+	file, value := filepath.Join(dir, "io.bfq.weight"), strconv.Itoa(int(weight))
+	if _, err := os.Stat(file); os.IsNotExist(err) {
+		file, value = filepath.Join(dir, "io.weight"), fmt.Sprintf("default %d", 1+(uint64(weight)-10)*9999/990)
+	}
+	return errors.Wrapf(os.WriteFile(file, []byte(value), constant.Perm0644), "write %s", file)
+}
+
+// cpuSharesToWeight 把 v1 的 cpu.shares（2 到 262144）换算为 v2 的 cpu.weight（1 到 10000），和 runc 的换算方式一致
+func cpuSharesToWeight(shares uint64) uint64 {
+	if shares < 2 {
+		shares = 2
+	}
+	return 1 + ((shares-2)*9999)/262142
+}
+
+// findOwnCgroupV2 通过 /proc/self/cgroup 找出当前进程所在的 cgroup，v2 中只有一行 0::<path>
+func findOwnCgroupV2() string {
+	f, err := os.Open("/proc/self/cgroup")
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if path, ok := strings.CutPrefix(scanner.Text(), "0::"); ok {
+			return path
+		}
+	}
+	return ""
+}
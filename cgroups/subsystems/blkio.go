@@ -0,0 +1,65 @@
+package subsystems
+
+import (
+	"fmt"
+	"mydocker/constant"
+	"os"
+	"path"
+	"strconv"
+)
+
+// BlkioSubsystem 块设备 I/O 的权重和限速
+type BlkioSubsystem struct{}
+
+func (s *BlkioSubsystem) Name() string {
+	return "blkio"
+}
+
+func (s *BlkioSubsystem) Set(cgroupPath string, cfg *ResourceConfig) error {
+	throttles := map[string][]string{
+		"blkio.throttle.read_bps_device":   cfg.ReadBpsDevice,
+		"blkio.throttle.write_bps_device":  cfg.WriteBpsDevice,
+		"blkio.throttle.read_iops_device":  cfg.ReadIOpsDevice,
+		"blkio.throttle.write_iops_device": cfg.WriteIOpsDevice,
+	}
+	if !blkioConfigured(cfg) {
+		return nil
+	}
+	subsysCgroupPath, err := getCgroupPath(s.Name(), cgroupPath, true)
+	if err != nil {
+		return err
+	}
+	if cfg.BlkioWeight != 0 {
+		// 只有 CFQ 调度器才有 blkio.weight，使用 BFQ 调度器时是 blkio.bfq.weight
+		weightFile := path.Join(subsysCgroupPath, "blkio.weight")
+		if _, err = os.Stat(weightFile); os.IsNotExist(err) {
+			weightFile = path.Join(subsysCgroupPath, "blkio.bfq.weight")
+		}
+		if err = os.WriteFile(weightFile, []byte(strconv.Itoa(int(cfg.BlkioWeight))), constant.Perm0644); err != nil {
+			return fmt.Errorf("set cgroup blkio weight failed %v", err)
+		}
+	}
+	// 每个设备的规则要单独写入
+	for file, rules := range throttles {
+		for _, rule := range rules {
+			if err = os.WriteFile(path.Join(subsysCgroupPath, file), []byte(rule), constant.Perm0644); err != nil {
+				return fmt.Errorf("set cgroup %s %s failed %v", file, rule, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *BlkioSubsystem) Apply(cgroupPath string, pid int, cfg *ResourceConfig) error {
+	return applyCgroup(s.Name(), cgroupPath, pid, blkioConfigured(cfg))
+}
+
+func (s *BlkioSubsystem) Remove(cgroupPath string) error {
+	return removeCgroup(s.Name(), cgroupPath)
+}
+
+// blkioConfigured 是否设置了块设备 I/O 的权重或者限速
+func blkioConfigured(cfg *ResourceConfig) bool {
+	return cfg.BlkioWeight != 0 || len(cfg.ReadBpsDevice) > 0 || len(cfg.WriteBpsDevice) > 0 ||
+		len(cfg.ReadIOpsDevice) > 0 || len(cfg.WriteIOpsDevice) > 0
+}
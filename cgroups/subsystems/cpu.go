@@ -47,25 +47,9 @@ func (s *CpuSubsystem) Set(cgroupPath string, cfg *ResourceConfig) error {
 }
 
 func (s *CpuSubsystem) Apply(cgroupPath string, pid int, cfg *ResourceConfig) error {
-	// 确保配置中存在 cpu.shares 或者 cpu.cfs_quota_us，如果没有就直接返回 nil，不进行其他操作
-	if cfg.CpuCfsQuota == 0 && cfg.CpuShare == "" {
-		return nil
-	}
-
-	subsysCgroupPath, err := getCgroupPath(s.Name(), cgroupPath, false)
-	if err != nil {
-		return fmt.Errorf("get cgroup %s error: %v", cgroupPath, err)
-	}
-	if err := os.WriteFile(path.Join(subsysCgroupPath, "tasks"), []byte(strconv.Itoa(pid)), constant.Perm0644); err != nil {
-		return fmt.Errorf("add process: %d to cgroup failed %v", pid, err)
-	}
-	return nil
+	return applyCgroup(s.Name(), cgroupPath, pid, cfg.CpuCfsQuota != 0 || cfg.CpuShare != "")
 }
 
 func (s *CpuSubsystem) Remove(cgroupPath string) error {
-	subsysCgroupPath, err := getCgroupPath(s.Name(), cgroupPath, false)
-	if err != nil {
-		return err
-	}
-	return os.RemoveAll(subsysCgroupPath)
+	return removeCgroup(s.Name(), cgroupPath)
 }
@@ -5,7 +5,7 @@ import (
 	"mydocker/constant"
 	"os"
 	"path"
-	"strconv"
+	"strings"
 
 	"github.com/pkg/errors"
 )
@@ -24,6 +24,9 @@ func (s *CpusetSubsystem) Set(cgroupPath string, cfg *ResourceConfig) error {
 	if err != nil {
 		return err
 	}
+	if err = initCpuset(subsysCgroupPath); err != nil {
+		return err
+	}
 	if err := os.WriteFile(path.Join(subsysCgroupPath, "cpuset.cpus"), []byte(cfg.CpuSet), constant.Perm0644); err != nil {
 		return fmt.Errorf("set cgroup cpuset failed %v", err)
 	}
@@ -31,23 +34,37 @@ func (s *CpusetSubsystem) Set(cgroupPath string, cfg *ResourceConfig) error {
 }
 
 func (s *CpusetSubsystem) Apply(cgroupPath string, pid int, cfg *ResourceConfig) error {
-	if cfg.CpuSet == "" {
-		return nil
-	}
-	subsysCgroupPath, err := getCgroupPath(s.Name(), cgroupPath, false)
-	if err != nil {
-		return errors.Wrapf(err, "get cgroup %s", cgroupPath)
-	}
-	if err := os.WriteFile(path.Join(subsysCgroupPath, "tasks"), []byte(strconv.Itoa(pid)), constant.Perm0644); err != nil {
-		return fmt.Errorf("add process: %d to cgroup failed %v", pid, err)
-	}
-	return nil
+	return applyCgroup(s.Name(), cgroupPath, pid, cfg.CpuSet != "")
 }
 
 func (s *CpusetSubsystem) Remove(cgroupPath string) error {
-	subsysCgroupPath, err := getCgroupPath(s.Name(), cgroupPath, false)
-	if err != nil {
-		return err
+	return removeCgroup(s.Name(), cgroupPath)
+}
+
+// initCpuset 新建的 cpuset cgroup 中 cpuset.cpus 和 cpuset.mems 都是空的，进程无法加入
+/*
+	需要从父节点复制，--cgroup-parent 中新建的父节点同样是空的，所以先递归初始化父节点，
+	hierarchy 的根节点一定有值，递归到那里就会结束。
+*/
+func initCpuset(dir string) error {
+	for _, file := range []string{"cpuset.cpus", "cpuset.mems"} {
+		content, err := os.ReadFile(path.Join(dir, file))
+		if err != nil {
+			return errors.Wrapf(err, "read %s", file)
+		}
+		if strings.TrimSpace(string(content)) != "" {
+			continue
+		}
+		parent := path.Dir(dir)
+		if err = initCpuset(parent); err != nil {
+			return err
+		}
+		if content, err = os.ReadFile(path.Join(parent, file)); err != nil {
+			return errors.Wrapf(err, "read %s", file)
+		}
+		if err = os.WriteFile(path.Join(dir, file), content, constant.Perm0644); err != nil {
+			return errors.Wrapf(err, "init %s", file)
+		}
 	}
-	return os.RemoveAll(subsysCgroupPath)
+	return nil
 }
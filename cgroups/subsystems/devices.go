@@ -0,0 +1,42 @@
+package subsystems
+
+import (
+	"fmt"
+	"mydocker/constant"
+	"os"
+	"path"
+)
+
+type DevicesSubsystem struct{}
+
+func (s *DevicesSubsystem) Name() string {
+	return "devices"
+}
+
+// Set 先禁止所有设备，再逐条允许 cfg.Devices 中的规则
+func (s *DevicesSubsystem) Set(cgroupPath string, cfg *ResourceConfig) error {
+	if cfg.Devices == nil {
+		return nil
+	}
+	subsysCgroupPath, err := getCgroupPath(s.Name(), cgroupPath, true)
+	if err != nil {
+		return err
+	}
+	if err = os.WriteFile(path.Join(subsysCgroupPath, "devices.deny"), []byte("a"), constant.Perm0644); err != nil {
+		return fmt.Errorf("set cgroup devices deny failed %v", err)
+	}
+	for _, rule := range cfg.Devices {
+		if err = os.WriteFile(path.Join(subsysCgroupPath, "devices.allow"), []byte(rule), constant.Perm0644); err != nil {
+			return fmt.Errorf("set cgroup devices allow %s failed %v", rule, err)
+		}
+	}
+	return nil
+}
+
+func (s *DevicesSubsystem) Apply(cgroupPath string, pid int, cfg *ResourceConfig) error {
+	return applyCgroup(s.Name(), cgroupPath, pid, cfg.Devices != nil)
+}
+
+func (s *DevicesSubsystem) Remove(cgroupPath string) error {
+	return removeCgroup(s.Name(), cgroupPath)
+}
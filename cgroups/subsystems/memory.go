@@ -5,13 +5,13 @@ import (
 	"mydocker/constant"
 	"os"
 	"path"
-	"strconv"
-
-	"github.com/pkg/errors"
 )
 
 type MemorySubsystem struct{}
 
+// unlimitedMemory 大于等于这个值的 memory.limit_in_bytes 表示不限制
+const unlimitedMemory = 1 << 62
+
 func (s *MemorySubsystem) Name() string {
 	return "memory"
 }
@@ -32,24 +32,35 @@ func (s *MemorySubsystem) Set(cgroupPath string, cfg *ResourceConfig) error {
 }
 
 func (s *MemorySubsystem) Apply(cgroupPath string, pid int, cfg *ResourceConfig) error {
-	if cfg.MemoryLimit == "" {
+	return applyCgroup(s.Name(), cgroupPath, pid, cfg.MemoryLimit != "")
+}
+
+func (s *MemorySubsystem) GetStats(cgroupPath string, stats *Stats) error {
+	if findCgroupMountpoint(s.Name()) == "" {
 		return nil
 	}
-
 	subsysCgroupPath, err := getCgroupPath(s.Name(), cgroupPath, false)
 	if err != nil {
-		return errors.Wrapf(err, "get cgroup %s", cgroupPath)
+		return err
 	}
-	if err := os.WriteFile(path.Join(subsysCgroupPath, "tasks"), []byte(strconv.Itoa(pid)), constant.Perm0644); err != nil {
-		return fmt.Errorf("add process: %d to cgroup failed %v", pid, err)
+	// 没有限制的 subsystem 不会创建容器的 cgroup
+	if _, err = os.Stat(subsysCgroupPath); os.IsNotExist(err) {
+		return nil
+	}
+	stats.HasMemory = true
+	if stats.MemoryUsage, err = ReadCgroupUint(path.Join(subsysCgroupPath, "memory.usage_in_bytes")); err != nil {
+		return err
+	}
+	if stats.MemoryLimit, err = ReadCgroupUint(path.Join(subsysCgroupPath, "memory.limit_in_bytes")); err != nil {
+		return err
+	}
+	// 没有限制时 memory.limit_in_bytes 是一个接近 int64 上限的值
+	if stats.MemoryLimit >= unlimitedMemory {
+		stats.MemoryLimit = 0
 	}
 	return nil
 }
 
 func (s *MemorySubsystem) Remove(cgroupPath string) error {
-	subsysCgroupPath, err := getCgroupPath(s.Name(), cgroupPath, false)
-	if err != nil {
-		return err
-	}
-	return os.RemoveAll(subsysCgroupPath)
+	return removeCgroup(s.Name(), cgroupPath)
 }
@@ -0,0 +1,66 @@
+package subsystems
+
+import (
+	"fmt"
+	"mydocker/constant"
+	"os"
+	"path"
+	"strconv"
+)
+
+// PidsSubsystem 限制 cgroup 中的进程数，防止容器中的 fork 炸弹耗尽宿主机的 PID
+type PidsSubsystem struct{}
+
+func (s *PidsSubsystem) Name() string {
+	return "pids"
+}
+
+func (s *PidsSubsystem) Set(cgroupPath string, cfg *ResourceConfig) error {
+	if cfg.PidsLimit == 0 {
+		return nil
+	}
+	subsysCgroupPath, err := getCgroupPath(s.Name(), cgroupPath, true)
+	if err != nil {
+		return err
+	}
+	if err = os.WriteFile(path.Join(subsysCgroupPath, "pids.max"), []byte(PidsMax(cfg.PidsLimit)), constant.Perm0644); err != nil {
+		return fmt.Errorf("set cgroup pids failed %v", err)
+	}
+	return nil
+}
+
+func (s *PidsSubsystem) Apply(cgroupPath string, pid int, cfg *ResourceConfig) error {
+	return applyCgroup(s.Name(), cgroupPath, pid, cfg.PidsLimit != 0)
+}
+
+func (s *PidsSubsystem) GetStats(cgroupPath string, stats *Stats) error {
+	if findCgroupMountpoint(s.Name()) == "" {
+		return nil
+	}
+	subsysCgroupPath, err := getCgroupPath(s.Name(), cgroupPath, false)
+	if err != nil {
+		return err
+	}
+	// 没有限制的 subsystem 不会创建容器的 cgroup
+	if _, err = os.Stat(subsysCgroupPath); os.IsNotExist(err) {
+		return nil
+	}
+	stats.HasPids = true
+	if stats.PidsCurrent, err = ReadCgroupUint(path.Join(subsysCgroupPath, "pids.current")); err != nil {
+		return err
+	}
+	stats.PidsLimit, err = ReadCgroupUint(path.Join(subsysCgroupPath, "pids.max"))
+	return err
+}
+
+func (s *PidsSubsystem) Remove(cgroupPath string) error {
+	return removeCgroup(s.Name(), cgroupPath)
+}
+
+// PidsMax 把 PidsLimit 转换为 pids.max 的内容，小于 0 表示不限制
+func PidsMax(limit int64) string {
+	if limit < 0 {
+		return "max"
+	}
+	return strconv.FormatInt(limit, 10)
+}
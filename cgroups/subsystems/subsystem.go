@@ -4,11 +4,21 @@ package subsystems
 // cpu 时间片限制
 // cpu 权重设置
 // cpu 亲合度设置
+// 允许访问的设备
 type ResourceConfig struct {
 	CpuCfsQuota int
 	CpuShare    string
 	CpuSet      string
 	MemoryLimit string
+	Devices     []string // device cgroup 规则，比如 c 1:3 rwm，为 nil 时不限制
+	PidsLimit   int64    // 最大进程数，为 0 时不设置，小于 0 时不限制
+
+	// 块设备 I/O 的权重和限速，限速规则的格式为 major:minor rate
+	BlkioWeight     uint16 // 10 到 1000，为 0 时不设置
+	ReadBpsDevice   []string
+	WriteBpsDevice  []string
+	ReadIOpsDevice  []string
+	WriteIOpsDevice []string
 }
 
 type Subsystem interface {
@@ -16,15 +26,35 @@ type Subsystem interface {
 	Name() string
 	// 设置某个 cgroup 的参数
 	Set(cgroupPath string, cfg *ResourceConfig) error
-	// 将某个进程添加到 cgroup 中
+	// 将某个进程添加到 cgroup 中，写入 cgroup.procs 会移动进程的所有线程，tasks 只会移动一个线程
 	Apply(cgroupPath string, pid int, cfg *ResourceConfig) error
 	// 删除某个 cgroup
 	Remove(cgroupPath string) error
 }
 
+// Stats 容器 cgroup 的资源使用情况，Limit 为 0 表示不限制
+// 容器没有自己的 memory 或 pids cgroup 时对应的 Has 为 false，其余字段没有意义
+type Stats struct {
+	HasMemory   bool
+	HasPids     bool
+	MemoryUsage uint64
+	MemoryLimit uint64
+	PidsCurrent uint64
+	PidsLimit   uint64
+}
+
+// StatsGetter 可以读取资源使用情况的 subsystem
+type StatsGetter interface {
+	// 把某个 cgroup 的资源使用情况填入 stats
+	GetStats(cgroupPath string, stats *Stats) error
+}
+
 // 通过不同的 subsystem 初始化实例创建资源限制组
 var SubsystemInts = []Subsystem{
 	&CpuSubsystem{},
 	&CpusetSubsystem{},
 	&MemorySubsystem{},
+	&DevicesSubsystem{},
+	&PidsSubsystem{},
+	&BlkioSubsystem{},
 }
@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"os"
 	"path"
+	"strconv"
 	"strings"
 
 	"mydocker/constant"
@@ -23,6 +24,14 @@ const mountPointIndex = 4
 func getCgroupPath(subsystemName string, cgroupPath string, autoCreate bool) (string, error) {
 	// cgroup 子系统的根目录路径
 	cgroupRootPath := findCgroupMountpoint(subsystemName)
+	// 没有挂载时根目录为空，拼出来的相对路径会把 cgroup 建在当前目录下
+	if cgroupRootPath == "" {
+		return "", errors.Errorf("cgroup subsystem %s is not mounted", subsystemName)
+	}
+	// 非 root 用户只能使用委派给自己的 cgroup，也就是当前进程所在 cgroup 的子节点
+	if os.Geteuid() != 0 {
+		cgroupRootPath = path.Join(cgroupRootPath, findOwnCgroup(subsystemName))
+	}
 	// 绝对路径
 	absPath := path.Join(cgroupRootPath, cgroupPath)
 	// 如果不需要创建就直接返回绝对路径
@@ -33,13 +42,64 @@ func getCgroupPath(subsystemName string, cgroupPath string, autoCreate bool) (st
 	_, err := os.Stat(absPath)
 	// 只有不存在才创建，这里如果不存在的话 err 会返回 not exist 的错误
 	if err != nil && os.IsNotExist(err) {
-		err = os.Mkdir(absPath, constant.Perm0755)
+		// 容器的 cgroup 在 --cgroup-parent 之下，父节点不存在时一起创建
+		err = os.MkdirAll(absPath, constant.Perm0755)
 		return absPath, err
 	}
 	// 其他错误或者没有错误都直接返回，如果 err == nil，那么 errors.Wrap(err, "") 也会是 nil
 	return absPath, errors.Wrap(err, "create cgroup")
 }
 
+// applyCgroup 把进程加入 subsystem 下的 cgroup
+/*
+	configured 为 true 时 subsystem 有限制，cgroup 不存在就创建；否则只加入之前 Set 创建过的 cgroup，
+	比如 update 命令后来加上的限制，不为没有限制或者没有挂载的 subsystem 创建 cgroup。
+*/
+func applyCgroup(subsystemName, cgroupPath string, pid int, configured bool) error {
+	if !configured && findCgroupMountpoint(subsystemName) == "" {
+		return nil
+	}
+	subsysCgroupPath, err := getCgroupPath(subsystemName, cgroupPath, configured)
+	if err != nil {
+		return errors.Wrapf(err, "get cgroup %s", cgroupPath)
+	}
+	if !configured {
+		if _, err = os.Stat(subsysCgroupPath); os.IsNotExist(err) {
+			return nil
+		}
+	}
+	if err = os.WriteFile(path.Join(subsysCgroupPath, "cgroup.procs"), []byte(strconv.Itoa(pid)), constant.Perm0644); err != nil {
+		return errors.Wrapf(err, "add process %d to cgroup", pid)
+	}
+	return nil
+}
+
+// removeCgroup 删除 subsystem 下的 cgroup，subsystem 没有挂载时什么都不做
+func removeCgroup(subsystemName, cgroupPath string) error {
+	if findCgroupMountpoint(subsystemName) == "" {
+		return nil
+	}
+	subsysCgroupPath, err := getCgroupPath(subsystemName, cgroupPath, false)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(subsysCgroupPath)
+}
+
+// ReadCgroupUint 读取 cgroup 中只有一个数字的文件，内容为 max 时表示不限制，返回 0
+func ReadCgroupUint(file string) (uint64, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return 0, errors.Wrapf(err, "read %s", file)
+	}
+	value := strings.TrimSpace(string(content))
+	if value == "max" {
+		return 0, nil
+	}
+	n, err := strconv.ParseUint(value, 10, 64)
+	return n, errors.Wrapf(err, "parse %s", file)
+}
+
 // findCgroupMountpoint 通过 /proc/self/mountinfo 找出挂载了某个 subsystem 的 hierarchy cgroup 根节点所在的目录
 func findCgroupMountpoint(subsystem string) string {
 	// /proc/self/mountinfo 为当前进程的 mountinfo 信息
@@ -75,3 +135,28 @@ func findCgroupMountpoint(subsystem string) string {
 	}
 	return ""
 }
+
+// findOwnCgroup 通过 /proc/self/cgroup 找出当前进程在某个 subsystem 中所在的 cgroup
+func findOwnCgroup(subsystem string) string {
+	f, err := os.Open("/proc/self/cgroup")
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// 格式为 hierarchy-ID:subsystem-list:cgroup-path，比如
+		// 4:memory:/user.slice/user-1000.slice
+		fields := strings.SplitN(scanner.Text(), ":", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		for _, opt := range strings.Split(fields[1], ",") {
+			if opt == subsystem {
+				return fields[2]
+			}
+		}
+	}
+	return ""
+}
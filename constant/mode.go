@@ -3,6 +3,7 @@ package constant
 const (
 	Perm0777 = 0777 // 用户、组用户和其它用户都有 RWX 权限
 	Perm0755 = 0755 // 用户具有 RWX 权限，组用户和其它用户具有 RW 权限
+	Perm0711 = 0711 // 用户具有 RWX 权限，组用户和其它用户只有 X 权限
 	Perm0644 = 0644 // 用户具有 RW 权限，组用户和其它用户具有 R 权限
 	Perm0622 = 0622 // 用户具有 RW 权限，组用户和其它用户具只 W 权限；
 )
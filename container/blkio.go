@@ -0,0 +1,56 @@
+package container
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseThrottleDevices 解析 --device-read-bps 这类参数，格式为 <宿主机上的块设备>:<速率>，比如 /dev/sda:10mb
+/*
+	返回 blkio 中使用的 major:minor rate 规则，设备号从宿主机的设备文件读取。
+	bps 的速率可以带 kb、mb、gb 单位，iops 的速率只能是整数。
+*/
+func ParseThrottleDevices(values []string, bps bool) ([]string, error) {
+	var rules []string
+	for _, value := range values {
+		i := strings.LastIndex(value, ":")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid device rate %s, format should be <device-path>:<rate>", value)
+		}
+		path, rate := value[:i], value[i+1:]
+		n, err := parseRate(rate, bps)
+		if err != nil {
+			return nil, fmt.Errorf("invalid device rate %s: %v", value, err)
+		}
+		d, err := deviceFromPath(path, path)
+		if err != nil {
+			return nil, err
+		}
+		if d.Type != 'b' {
+			return nil, fmt.Errorf("%s is not a block device", path)
+		}
+		rules = append(rules, fmt.Sprintf("%d:%d %d", d.Major, d.Minor, n))
+	}
+	return rules, nil
+}
+
+func parseRate(rate string, bps bool) (uint64, error) {
+	var n uint64
+	if bps {
+		size, err := ParseSize(rate)
+		if err != nil {
+			return 0, err
+		}
+		n = uint64(size)
+	} else {
+		var err error
+		if n, err = strconv.ParseUint(rate, 10, 64); err != nil {
+			return 0, fmt.Errorf("rate %s should be a number", rate)
+		}
+	}
+	if n == 0 {
+		return 0, fmt.Errorf("rate must be positive")
+	}
+	return n, nil
+}
@@ -0,0 +1,34 @@
+package container
+
+import "testing"
+
+func TestParseRate(t *testing.T) {
+	cases := []struct {
+		rate string
+		bps  bool
+		want uint64
+	}{
+		{"10mb", true, 10 << 20},
+		{"512", true, 512},
+		{"1000", false, 1000},
+	}
+	for _, c := range cases {
+		got, err := parseRate(c.rate, c.bps)
+		if err != nil || got != c.want {
+			t.Errorf("parseRate(%q, %v) = %d, %v, want %d", c.rate, c.bps, got, err, c.want)
+		}
+	}
+	for _, rate := range []string{"0", "10mb", "-1"} {
+		if _, err := parseRate(rate, false); err == nil {
+			t.Errorf("parseRate(%q, false) expect error", rate)
+		}
+	}
+}
+
+func TestParseThrottleDevices(t *testing.T) {
+	for _, value := range []string{"/dev/null", "/dev/null:10mb", "/dev/not-exist:10mb"} {
+		if _, err := ParseThrottleDevices([]string{value}, true); err == nil {
+			t.Errorf("ParseThrottleDevices(%q) expect error", value)
+		}
+	}
+}
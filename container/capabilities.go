@@ -0,0 +1,301 @@
+package container
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// capAll --cap-add 和 --cap-drop 中表示全部 capability 的值
+const capAll = "ALL"
+
+// capabilityList capability 名字到编号的映射
+var capabilityList = map[string]uint{
+	"CAP_CHOWN":              unix.CAP_CHOWN,
+	"CAP_DAC_OVERRIDE":       unix.CAP_DAC_OVERRIDE,
+	"CAP_DAC_READ_SEARCH":    unix.CAP_DAC_READ_SEARCH,
+	"CAP_FOWNER":             unix.CAP_FOWNER,
+	"CAP_FSETID":             unix.CAP_FSETID,
+	"CAP_KILL":               unix.CAP_KILL,
+	"CAP_SETGID":             unix.CAP_SETGID,
+	"CAP_SETUID":             unix.CAP_SETUID,
+	"CAP_SETPCAP":            unix.CAP_SETPCAP,
+	"CAP_LINUX_IMMUTABLE":    unix.CAP_LINUX_IMMUTABLE,
+	"CAP_NET_BIND_SERVICE":   unix.CAP_NET_BIND_SERVICE,
+	"CAP_NET_BROADCAST":      unix.CAP_NET_BROADCAST,
+	"CAP_NET_ADMIN":          unix.CAP_NET_ADMIN,
+	"CAP_NET_RAW":            unix.CAP_NET_RAW,
+	"CAP_IPC_LOCK":           unix.CAP_IPC_LOCK,
+	"CAP_IPC_OWNER":          unix.CAP_IPC_OWNER,
+	"CAP_SYS_MODULE":         unix.CAP_SYS_MODULE,
+	"CAP_SYS_RAWIO":          unix.CAP_SYS_RAWIO,
+	"CAP_SYS_CHROOT":         unix.CAP_SYS_CHROOT,
+	"CAP_SYS_PTRACE":         unix.CAP_SYS_PTRACE,
+	"CAP_SYS_PACCT":          unix.CAP_SYS_PACCT,
+	"CAP_SYS_ADMIN":          unix.CAP_SYS_ADMIN,
+	"CAP_SYS_BOOT":           unix.CAP_SYS_BOOT,
+	"CAP_SYS_NICE":           unix.CAP_SYS_NICE,
+	"CAP_SYS_RESOURCE":       unix.CAP_SYS_RESOURCE,
+	"CAP_SYS_TIME":           unix.CAP_SYS_TIME,
+	"CAP_SYS_TTY_CONFIG":     unix.CAP_SYS_TTY_CONFIG,
+	"CAP_MKNOD":              unix.CAP_MKNOD,
+	"CAP_LEASE":              unix.CAP_LEASE,
+	"CAP_AUDIT_WRITE":        unix.CAP_AUDIT_WRITE,
+	"CAP_AUDIT_CONTROL":      unix.CAP_AUDIT_CONTROL,
+	"CAP_SETFCAP":            unix.CAP_SETFCAP,
+	"CAP_MAC_OVERRIDE":       unix.CAP_MAC_OVERRIDE,
+	"CAP_MAC_ADMIN":          unix.CAP_MAC_ADMIN,
+	"CAP_SYSLOG":             unix.CAP_SYSLOG,
+	"CAP_WAKE_ALARM":         unix.CAP_WAKE_ALARM,
+	"CAP_BLOCK_SUSPEND":      unix.CAP_BLOCK_SUSPEND,
+	"CAP_AUDIT_READ":         unix.CAP_AUDIT_READ,
+	"CAP_PERFMON":            unix.CAP_PERFMON,
+	"CAP_BPF":                unix.CAP_BPF,
+	"CAP_CHECKPOINT_RESTORE": unix.CAP_CHECKPOINT_RESTORE,
+}
+
+// DefaultCapabilities 容器默认保留的 capability，与 Docker 的默认值一致
+var DefaultCapabilities = []string{
+	"CAP_CHOWN",
+	"CAP_DAC_OVERRIDE",
+	"CAP_FSETID",
+	"CAP_FOWNER",
+	"CAP_MKNOD",
+	"CAP_NET_RAW",
+	"CAP_SETGID",
+	"CAP_SETUID",
+	"CAP_SETFCAP",
+	"CAP_SETPCAP",
+	"CAP_NET_BIND_SERVICE",
+	"CAP_SYS_CHROOT",
+	"CAP_KILL",
+	"CAP_AUDIT_WRITE",
+}
+
+// Capabilities 用户进程的各个 capability 集合，与 OCI config.json 中的 process.capabilities 一致
+type Capabilities struct {
+	Bounding    []string `json:"bounding"`
+	Effective   []string `json:"effective"`
+	Inheritable []string `json:"inheritable"`
+	Permitted   []string `json:"permitted"`
+	Ambient     []string `json:"ambient"`
+}
+
+// NewCapabilities 生成 bounding、effective、permitted 都为 caps 的集合
+/*
+	和 Docker、runc 一样 inheritable 和 ambient 为空，否则非 root 用户执行带有 inheritable 文件 capability 的程序时
+	会重新获得这些 capability（CVE-2022-24769）。
+*/
+func NewCapabilities(caps []string) *Capabilities {
+	return &Capabilities{
+		Bounding:  caps,
+		Effective: caps,
+		Permitted: caps,
+	}
+}
+
+// Without 返回从各个集合中去掉 name 之后的 capability，不修改原来的集合
+func (c *Capabilities) Without(name string) *Capabilities {
+	without := func(caps []string) []string {
+		var result []string
+		for _, v := range caps {
+			if v != name {
+				result = append(result, v)
+			}
+		}
+		return result
+	}
+	return &Capabilities{
+		Bounding:    without(c.Bounding),
+		Effective:   without(c.Effective),
+		Inheritable: without(c.Inheritable),
+		Permitted:   without(c.Permitted),
+		Ambient:     without(c.Ambient),
+	}
+}
+
+// AllCapabilities 按编号排序的所有 capability
+/*
+	和 Docker 一样只返回当前进程 bounding set 中存在的 capability，
+	mydocker 本身运行在受限的环境（比如另一个容器）中时，无法把自己没有的 capability 交给容器。
+*/
+func AllCapabilities() []string {
+	caps := make([]string, 0, len(capabilityList))
+	for name, n := range capabilityList {
+		if ok, err := unix.PrctlRetInt(unix.PR_CAPBSET_READ, uintptr(n), 0, 0, 0); err != nil || ok != 1 {
+			continue
+		}
+		caps = append(caps, name)
+	}
+	sortCapabilities(caps)
+	return caps
+}
+
+// sortCapabilities 按 capability 编号排序
+func sortCapabilities(caps []string) {
+	sort.Slice(caps, func(i, j int) bool { return capabilityList[caps[i]] < capabilityList[caps[j]] })
+}
+
+// normalizeCapability 统一 capability 的写法，net_admin 和 NET_ADMIN 都转换为 CAP_NET_ADMIN
+func normalizeCapability(name string) (string, error) {
+	name = strings.ToUpper(name)
+	if name == capAll {
+		return name, nil
+	}
+	if !strings.HasPrefix(name, "CAP_") {
+		name = "CAP_" + name
+	}
+	if _, ok := capabilityList[name]; !ok {
+		return "", fmt.Errorf("unknown capability %s", name)
+	}
+	return name, nil
+}
+
+// MergeCapabilities 在 base 的基础上先去掉 drop 再加上 add，ALL 表示全部 capability
+/*
+	和 Docker 一样，同一个 capability 同时出现在 --cap-add 和 --cap-drop 中时以 --cap-add 为准，
+	--cap-drop ALL --cap-add NET_ADMIN 表示只保留 NET_ADMIN。
+*/
+func MergeCapabilities(base, add, drop []string) ([]string, error) {
+	set := map[string]bool{}
+	for _, c := range base {
+		set[c] = true
+	}
+	for _, c := range drop {
+		name, err := normalizeCapability(c)
+		if err != nil {
+			return nil, err
+		}
+		if name == capAll {
+			set = map[string]bool{}
+			continue
+		}
+		delete(set, name)
+	}
+	for _, c := range add {
+		name, err := normalizeCapability(c)
+		if err != nil {
+			return nil, err
+		}
+		if name == capAll {
+			return AllCapabilities(), nil
+		}
+		set[name] = true
+	}
+	caps := []string{}
+	for name := range set {
+		caps = append(caps, name)
+	}
+	sortCapabilities(caps)
+	return caps, nil
+}
+
+// capSet 转换为位图之后的 capability 集合
+type capSet struct {
+	bounding, effective, inheritable, permitted, ambient uint64
+}
+
+// newCapSet 将 Capabilities 转换为位图，c 为 nil 时保持当前进程的 capability 不变
+func newCapSet(c *Capabilities) (*capSet, error) {
+	if c == nil {
+		eff, perm, inh, err := capget()
+		if err != nil {
+			return nil, err
+		}
+		return &capSet{bounding: ^uint64(0), effective: eff, permitted: perm, inheritable: inh}, nil
+	}
+	s := &capSet{}
+	for _, v := range []struct {
+		mask  *uint64
+		names []string
+	}{
+		{&s.bounding, c.Bounding},
+		{&s.effective, c.Effective},
+		{&s.inheritable, c.Inheritable},
+		{&s.permitted, c.Permitted},
+		{&s.ambient, c.Ambient},
+	} {
+		for _, name := range v.names {
+			n, ok := capabilityList[name]
+			if !ok {
+				// 当前版本不认识的 capability 与 runc 一样只打印警告
+				log.Warnf("unknown capability %s, ignored", name)
+				continue
+			}
+			*v.mask |= 1 << n
+		}
+	}
+	return s, nil
+}
+
+// lastCap 内核支持的最大 capability 编号
+func lastCap() uint {
+	content, err := os.ReadFile("/proc/sys/kernel/cap_last_cap")
+	if err != nil {
+		return unix.CAP_LAST_CAP
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil {
+		return unix.CAP_LAST_CAP
+	}
+	return uint(n)
+}
+
+// dropBounding 从 bounding set 中去掉不需要的 capability 并清空 ambient set，需要 CAP_SETPCAP
+func (s *capSet) dropBounding() error {
+	for n := uint(0); n <= lastCap(); n++ {
+		if s.bounding&(1<<n) != 0 {
+			continue
+		}
+		if err := unix.Prctl(unix.PR_CAPBSET_DROP, uintptr(n), 0, 0, 0); err != nil {
+			return errors.Wrapf(err, "drop capability %d from bounding set", n)
+		}
+	}
+	return errors.Wrap(unix.Prctl(unix.PR_CAP_AMBIENT, unix.PR_CAP_AMBIENT_CLEAR_ALL, 0, 0, 0), "clear ambient capabilities")
+}
+
+// apply 设置 effective、permitted 和 inheritable，extra 中的 capability 会临时保留在 effective 和 permitted 中
+func (s *capSet) apply(extra uint64) error {
+	return capset(s.effective|extra, s.permitted|extra, s.inheritable)
+}
+
+// raiseAmbient 设置 ambient set，ambient 中的 capability 必须同时在 permitted 和 inheritable 中
+func (s *capSet) raiseAmbient() error {
+	for n := uint(0); n <= lastCap(); n++ {
+		if s.ambient&(1<<n) == 0 {
+			continue
+		}
+		if err := unix.Prctl(unix.PR_CAP_AMBIENT, unix.PR_CAP_AMBIENT_RAISE, uintptr(n), 0, 0); err != nil {
+			return errors.Wrapf(err, "raise ambient capability %d", n)
+		}
+	}
+	return nil
+}
+
+// capget 读取当前进程的 effective、permitted 和 inheritable，64 位的集合分成两个 32 位保存
+func capget() (eff, perm, inh uint64, err error) {
+	hdr := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+	var data [2]unix.CapUserData
+	if err = unix.Capget(&hdr, &data[0]); err != nil {
+		return 0, 0, 0, errors.Wrap(err, "capget")
+	}
+	eff = uint64(data[1].Effective)<<32 | uint64(data[0].Effective)
+	perm = uint64(data[1].Permitted)<<32 | uint64(data[0].Permitted)
+	inh = uint64(data[1].Inheritable)<<32 | uint64(data[0].Inheritable)
+	return eff, perm, inh, nil
+}
+
+func capset(eff, perm, inh uint64) error {
+	hdr := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+	data := [2]unix.CapUserData{
+		{Effective: uint32(eff), Permitted: uint32(perm), Inheritable: uint32(inh)},
+		{Effective: uint32(eff >> 32), Permitted: uint32(perm >> 32), Inheritable: uint32(inh >> 32)},
+	}
+	return errors.Wrap(unix.Capset(&hdr, &data[0]), "capset")
+}
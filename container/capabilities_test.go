@@ -0,0 +1,51 @@
+package container
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMergeCapabilities(t *testing.T) {
+	cases := []struct {
+		base, add, drop []string
+		want            []string
+	}{
+		{[]string{"CAP_KILL", "CAP_CHOWN"}, nil, nil, []string{"CAP_CHOWN", "CAP_KILL"}},
+		{[]string{"CAP_KILL", "CAP_CHOWN"}, []string{"net_admin"}, []string{"CAP_KILL"}, []string{"CAP_CHOWN", "CAP_NET_ADMIN"}},
+		{[]string{"CAP_KILL"}, []string{"KILL"}, []string{"ALL"}, []string{"CAP_KILL"}},
+		{[]string{"CAP_KILL"}, nil, []string{"all"}, []string{}},
+	}
+	for _, c := range cases {
+		got, err := MergeCapabilities(c.base, c.add, c.drop)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("MergeCapabilities(%v, %v, %v) = %v, want %v", c.base, c.add, c.drop, got, c.want)
+		}
+	}
+	if _, err := MergeCapabilities(nil, []string{"FOO"}, nil); err == nil {
+		t.Error("expect error for unknown capability")
+	}
+}
+
+func TestNewCapabilities(t *testing.T) {
+	caps := NewCapabilities([]string{"CAP_CHOWN", "CAP_KILL"})
+	if len(caps.Inheritable) != 0 || len(caps.Ambient) != 0 {
+		t.Errorf("NewCapabilities inheritable = %v, ambient = %v, want empty", caps.Inheritable, caps.Ambient)
+	}
+	if !reflect.DeepEqual(caps.Bounding, []string{"CAP_CHOWN", "CAP_KILL"}) {
+		t.Errorf("NewCapabilities bounding = %v", caps.Bounding)
+	}
+}
+
+func TestCapabilitiesWithout(t *testing.T) {
+	caps := NewCapabilities([]string{"CAP_CHOWN", "CAP_MKNOD"})
+	got := caps.Without("CAP_MKNOD")
+	if !reflect.DeepEqual(got.Bounding, []string{"CAP_CHOWN"}) || !reflect.DeepEqual(got.Permitted, []string{"CAP_CHOWN"}) {
+		t.Errorf("Without = %+v", got)
+	}
+	if len(caps.Effective) != 2 {
+		t.Errorf("Without modified the original set %v", caps.Effective)
+	}
+}
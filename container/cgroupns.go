@@ -0,0 +1,70 @@
+package container
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const cgroupRoot = "/sys/fs/cgroup"
+
+// CgroupMounts 根据宿主机上 cgroup 的挂载方式生成容器 /sys/fs/cgroup 的挂载点
+/*
+	init 在加入容器的 cgroup 之后才创建 cgroup namespace，在 namespace 中挂载的 cgroup 文件系统以容器自己的 cgroup 为根，
+	JVM 这类程序就能从中读到容器的内存和 CPU 限制。cgroup v1 的每个 hierarchy 挂载到 tmpfs 下对应的目录，
+	只有 cgroup v2 时直接挂载到 /sys/fs/cgroup。
+*/
+func CgroupMounts(readonly bool) ([]Mount, error) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil, errors.Wrap(err, "open mountinfo")
+	}
+	defer f.Close()
+	return cgroupMounts(f, readonly)
+}
+
+func cgroupMounts(mountinfo io.Reader, readonly bool) ([]Mount, error) {
+	flags := []string{"nosuid", "noexec", "nodev"}
+	if readonly {
+		flags = append(flags, "ro")
+	}
+	var mounts []Mount
+	scanner := bufio.NewScanner(mountinfo)
+	for scanner.Scan() {
+		// 格式为 36 25 0:31 / /sys/fs/cgroup/memory rw,nosuid,nodev,noexec,relatime shared:14 - cgroup cgroup rw,memory
+		before, after, ok := strings.Cut(scanner.Text(), " - ")
+		fields, superFields := strings.Fields(before), strings.Fields(after)
+		if !ok || len(fields) < 5 || len(superFields) < 3 {
+			continue
+		}
+		mountpoint, fstype := fields[4], superFields[0]
+		if fstype != "cgroup" && fstype != "cgroup2" || mountpoint != cgroupRoot && !strings.HasPrefix(mountpoint, cgroupRoot+"/") {
+			continue
+		}
+		if mountpoint == cgroupRoot {
+			return []Mount{{Source: "cgroup", Destination: cgroupRoot, Type: fstype, Options: flags}}, nil
+		}
+		options := append([]string{}, flags...)
+		if fstype == "cgroup" {
+			// 保留 controller 和 name= 这些选项，release_agent 在 cgroup namespace 中不允许设置
+			for _, o := range strings.Split(superFields[2], ",") {
+				if o != "rw" && o != "ro" && !strings.HasPrefix(o, "release_agent=") {
+					options = append(options, o)
+				}
+			}
+		}
+		mounts = append(mounts, Mount{Source: "cgroup", Destination: mountpoint, Type: fstype, Options: options})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "read mountinfo")
+	}
+	if len(mounts) == 0 {
+		return nil, errors.Errorf("no cgroup filesystem mounted at %s", cgroupRoot)
+	}
+	// tmpfs 本身由 ReadonlyPaths 在最后改为只读，否则无法在其中创建各个 hierarchy 的挂载点
+	tmpfs := Mount{Source: "tmpfs", Destination: cgroupRoot, Type: "tmpfs", Options: []string{"nosuid", "noexec", "nodev", "mode=755"}}
+	return append([]Mount{tmpfs}, mounts...), nil
+}
@@ -0,0 +1,43 @@
+package container
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCgroupMounts(t *testing.T) {
+	v1 := "25 30 0:23 / /sys rw,nosuid,nodev,noexec,relatime shared:7 - sysfs sysfs rw\n" +
+		"26 25 0:24 / /sys/fs/cgroup ro,nosuid,nodev,noexec shared:9 - tmpfs tmpfs ro,mode=755\n" +
+		"27 26 0:25 / /sys/fs/cgroup/unified rw,nosuid,nodev,noexec,relatime shared:10 - cgroup2 cgroup2 rw,nsdelegate\n" +
+		"28 26 0:26 / /sys/fs/cgroup/systemd rw,nosuid,nodev,noexec,relatime shared:11 - cgroup cgroup rw,xattr,name=systemd,release_agent=/lib/systemd/systemd-cgroups-agent\n" +
+		"29 26 0:27 / /sys/fs/cgroup/cpu,cpuacct rw,nosuid,nodev,noexec,relatime shared:12 - cgroup cgroup rw,cpu,cpuacct\n" +
+		"30 25 0:28 / /mnt/cgroup rw,relatime shared:13 - cgroup cgroup rw,memory\n"
+	got, err := cgroupMounts(strings.NewReader(v1), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Mount{
+		{Source: "tmpfs", Destination: "/sys/fs/cgroup", Type: "tmpfs", Options: []string{"nosuid", "noexec", "nodev", "mode=755"}},
+		{Source: "cgroup", Destination: "/sys/fs/cgroup/unified", Type: "cgroup2", Options: []string{"nosuid", "noexec", "nodev", "ro"}},
+		{Source: "cgroup", Destination: "/sys/fs/cgroup/systemd", Type: "cgroup", Options: []string{"nosuid", "noexec", "nodev", "ro", "xattr", "name=systemd"}},
+		{Source: "cgroup", Destination: "/sys/fs/cgroup/cpu,cpuacct", Type: "cgroup", Options: []string{"nosuid", "noexec", "nodev", "ro", "cpu", "cpuacct"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("cgroupMounts v1 = %+v, want %+v", got, want)
+	}
+
+	v2 := "26 25 0:24 / /sys/fs/cgroup rw,nosuid,nodev,noexec,relatime shared:9 - cgroup2 cgroup2 rw,nsdelegate,memory_recursiveprot\n"
+	got, err = cgroupMounts(strings.NewReader(v2), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []Mount{{Source: "cgroup", Destination: "/sys/fs/cgroup", Type: "cgroup2", Options: []string{"nosuid", "noexec", "nodev"}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("cgroupMounts v2 = %+v, want %+v", got, want)
+	}
+
+	if _, err = cgroupMounts(strings.NewReader("25 30 0:23 / /sys rw - sysfs sysfs rw\n"), true); err == nil {
+		t.Error("cgroupMounts without cgroup expect error")
+	}
+}
@@ -3,43 +3,109 @@ package container
 import (
 	"fmt"
 	"mydocker/constant"
+	"mydocker/oci"
+	"mydocker/seccomp"
 	"os"
 	"os/exec"
 	"syscall"
 
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
 )
 
 const (
-	RUNNING       = "running"
-	STOP          = "stopped"
-	Exit          = "exited"
-	InfoLoc       = "/var/run/mydocker/"
+	CREATED      = "created"
+	RUNNING      = "running"
+	STOP         = "stopped"
+	Exit         = "exited"
+	ConfigName   = "config.json"
+	IDLength     = 10
+	Logfile      = "container.log"
+	ExecFifoName = "exec.fifo"
+)
+
+// 非 root 用户没有权限写 /var/run 和 /root，rootless 模式下改用用户自己的目录
+var (
+	InfoLoc       = runtimeDir()
 	InfoLocFormat = InfoLoc + "%s/"
-	ConfigName    = "config.json"
-	IDLength      = 10
-	Logfile       = "container.log"
 )
 
 // 容器目录相关
+var (
+	RootPath        = dataDir()
+	lowerDirFormat  = RootPath + "%s/lower"
+	upperDirFormat  = RootPath + "%s/upper"
+	workDirFormat   = RootPath + "%s/work"
+	mergedDirFormat = RootPath + "%s/merged"
+)
+
 const (
-	RootPath        = "/root/"
-	lowerDirFormat  = "/root/%s/lower"
-	upperDirFormat  = "/root/%s/upper"
-	workDirFormat   = "/root/%s/work"
-	mergedDirFormat = "/root/%s/merged"
 	overlayFSFormat = "lowerdir=%s,upperdir=%s,workdir=%s"
+	// root 使用 user namespace 时 rootfs 的路径，目录需要所有人都能进入
+	userNSRootfsFormat = "/var/run/mydocker-userns/%s"
 )
 
+// DefaultCloneflags 容器默认使用的 namespace
+const DefaultCloneflags = syscall.CLONE_NEWUTS | syscall.CLONE_NEWPID | syscall.CLONE_NEWNS | syscall.CLONE_NEWNET | syscall.CLONE_NEWIPC |
+	syscall.CLONE_NEWCGROUP
+
 type Info struct {
-	Pid         string   `json:"pid"`         // 容器的init进程在宿主机上的 PID
-	Id          string   `json:"id"`          // 容器Id
-	Name        string   `json:"name"`        // 容器名
-	Command     string   `json:"command"`     // 容器内init运行命令
-	CreatedTime string   `json:"createTime"`  // 创建时间
-	Status      string   `json:"status"`      // 容器的状态
-	Volume      string   `json:"volume"`      // 挂载的数据卷
-	PortMapping []string `json:"portmapping"` // 端口映射
+	Pid         string     `json:"pid"`                // 容器的init进程在宿主机上的 PID
+	Id          string     `json:"id"`                 // 容器Id
+	Name        string     `json:"name"`               // 容器名
+	Command     string     `json:"command"`            // 容器内init运行命令
+	CreatedTime string     `json:"createTime"`         // 创建时间
+	Status      string     `json:"status"`             // 容器的状态
+	Volume      string     `json:"volume"`             // 挂载的数据卷
+	PortMapping []string   `json:"portmapping"`        // 端口映射
+	Bundle      string     `json:"bundle,omitempty"`   // OCI bundle 路径，从 bundle 创建的容器才有
+	Hooks       *oci.Hooks `json:"hooks,omitempty"`    // 生命周期 hook，poststop 需要在删除容器时执行
+	SlirpPid    int        `json:"slirpPid,omitempty"` // rootless 容器的 slirp4netns 进程
+	IP          string     `json:"ip,omitempty"`       // 容器在网络中的 IP 地址
+	Network     string     `json:"network,omitempty"`  // 容器连接的网络，删除容器时释放 IP
+	Pod         string     `json:"pod,omitempty"`      // 容器所属的 pod
+
+	CgroupPath string `json:"cgroupPath,omitempty"` // 容器的 cgroup，相对于各个 hierarchy 的根节点，删除容器时一起删除
+
+	Capabilities *Capabilities    `json:"capabilities,omitempty"` // 用户进程的 capability，exec 时沿用
+	Seccomp      *seccomp.Profile `json:"seccomp,omitempty"`      // 容器使用的 seccomp profile，exec 时沿用
+	User         string           `json:"user,omitempty"`         // 用户进程的 user[:group]，exec 时沿用
+	GroupAdd     []string         `json:"groupAdd,omitempty"`     // 用户进程的附加组，exec 时沿用
+	Workdir      string           `json:"workdir,omitempty"`      // 用户进程的工作目录，exec 时沿用
+	Rlimits      []Rlimit         `json:"rlimits,omitempty"`      // 用户进程的资源限制，exec 时沿用
+
+	NoNewPrivileges bool `json:"noNewPrivileges,omitempty"` // 用户进程设置了 no_new_privs，exec 时沿用
+}
+
+// ParentOptions 创建容器进程需要的参数
+type ParentOptions struct {
+	Detach        bool // 后台运行的容器把输出写到日志文件中，其他情况由调用者设置标准输入输出
+	Volume        string
+	ContainerName string
+	ImageName     string
+	Rootfs        string      // 直接使用已有的 rootfs，比如 OCI bundle，此时不会创建 overlay 工作目录
+	Env           []string    // 容器的环境变量
+	Cloneflags    uintptr     // 为 0 时使用 DefaultCloneflags
+	IDMappings    *IDMappings // 不为 nil 时容器运行在新的 user namespace 中
+}
+
+// CreateExecFifo 在容器信息目录下创建 exec.fifo，返回一个 O_PATH 打开的 fd 用于传给 init
+/*
+	init 完成初始化之后会以写方式重新打开这个 fd，由于 fifo 在没有读者时 open 会阻塞，
+	init 就会停在执行用户进程之前，直到 start 命令以读方式打开 fifo。
+*/
+func CreateExecFifo(containerName string) (*os.File, error) {
+	dirPath := fmt.Sprintf(InfoLocFormat, containerName)
+	if err := os.MkdirAll(dirPath, constant.Perm0755); err != nil {
+		return nil, errors.Wrapf(err, "mkdir %s", dirPath)
+	}
+	fifoPath := dirPath + ExecFifoName
+	if err := unix.Mkfifo(fifoPath, constant.Perm0622); err != nil {
+		return nil, errors.Wrapf(err, "mkfifo %s", fifoPath)
+	}
+	fifo, err := os.OpenFile(fifoPath, unix.O_PATH|unix.O_CLOEXEC, 0)
+	return fifo, errors.Wrapf(err, "open %s", fifoPath)
 }
 
 // NewParentProcess 构建 command 用于启动一个新进程
@@ -50,7 +116,7 @@ type Info struct {
 	3. 下面的 clone 参数就是去 fork 出来一个新进程，并且使用了 namespace 隔离新创建的进程和外部环境。
 	4. 如果用户指定了 -it 参数，就需要把当前进程的输入输出导入到标准输入输出上
 */
-func NewParentProcess(tty bool, volume, containerName, imageName string, envSlice []string) (*exec.Cmd, *os.File) {
+func NewParentProcess(opts *ParentOptions) (*exec.Cmd, *os.File) {
 	// 创建匿名管道用于传递参数，将 readPipe 作为子进程的 ExtraFiles，子进程从 readPipe 中读取参数
 	// 父进程中则通过 writePipe 将参数写入管道
 	// fmt.Println("===New===")
@@ -66,35 +132,32 @@ func NewParentProcess(tty bool, volume, containerName, imageName string, envSlic
 		log.Errorf("get init process error: %v", err)
 		return nil, nil
 	}
+	cloneflags := opts.Cloneflags
+	if cloneflags == 0 {
+		cloneflags = DefaultCloneflags
+	}
 	cmd := exec.Command(initCmd, "init")
+	// cgroup namespace 以创建时所在的 cgroup 为根，所以由 init 在父进程把它加入容器的 cgroup 之后再创建
 	cmd.SysProcAttr = &syscall.SysProcAttr{
-		Cloneflags: syscall.CLONE_NEWUTS | syscall.CLONE_NEWPID | syscall.CLONE_NEWNS | syscall.CLONE_NEWNET | syscall.CLONE_NEWIPC,
-		// | syscall.CLONE_NEWUSER
-		// 新的命名空间设置 id 映射，即可以通过非 root 用户来在新 namespace 中使用 root 的权限
-		// UidMappings: []syscall.SysProcIDMap{
-		// 	{
-		// 		ContainerID: 0,
-		// 		HostID:      os.Getuid(),
-		// 		Size:        1,
-		// 	},
-		// },
-		// GidMappings: []syscall.SysProcIDMap{
-		// 	{
-		// 		ContainerID: 0,
-		// 		HostID:      os.Getgid(),
-		// 		Size:        1,
-		// 	},
-		// },
-		Setsid: true,
+		Cloneflags: cloneflags &^ syscall.CLONE_NEWCGROUP,
+		Setsid:     true,
+	}
+	if idMap := opts.IDMappings; idMap != nil {
+		cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWUSER
+		// root 可以直接写入任意的 id 映射，非 root 用户则要在进程启动之后通过 newuidmap 和 newgidmap 写入
+		if !Rootless() {
+			cmd.SysProcAttr.UidMappings = idMap.UIDs
+			cmd.SysProcAttr.GidMappings = idMap.GIDs
+			cmd.SysProcAttr.GidMappingsEnableSetgroups = true
+			// 宿主机的 root 不在映射范围内，需要在 exec 之前切换为容器内的 root，否则 exec 之后会丢失所有 capability
+			cmd.SysProcAttr.Credential = &syscall.Credential{Uid: 0, Gid: 0}
+		}
 	}
-	if tty {
-		cmd.Stdin = os.Stdin
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-	} else {
+	// 前台运行的容器和 create 命令创建的容器由调用者设置标准输入输出
+	if opts.Detach {
 		// 后台运行的容器，将输出到日志中
-		dirPath := fmt.Sprintf(InfoLocFormat, containerName)
-		if err := os.MkdirAll(dirPath, constant.Perm0622); err != nil {
+		dirPath := fmt.Sprintf(InfoLocFormat, opts.ContainerName)
+		if err := os.MkdirAll(dirPath, constant.Perm0755); err != nil {
 			log.Errorf("NewParentProcess mkdir %s error: %v", dirPath, err)
 			return nil, nil
 		}
@@ -104,16 +167,27 @@ func NewParentProcess(tty bool, volume, containerName, imageName string, envSlic
 			log.Errorf("NewParantProcess create file %s error: %v", stdLogFilePath, err)
 		}
 		cmd.Stdout = stdLogFile
+		cmd.Stderr = stdLogFile
 	}
 	cmd.ExtraFiles = []*os.File{readPipe}
-	// 指定 rootfs
-	// cmd.Dir = "/root/busybox"
-	// rootPath := "/root"
-	// mntPath := "/root/merged"
-	// NewWorkSpace(rootPath, mntPath, volume)
-	// cmd.Dir = mntPath
-	cmd.Dir = fmt.Sprintf(mergedDirFormat, containerName)
-	cmd.Env = append(os.Environ(), envSlice...)
-	NewWorkSpace(volume, imageName, containerName)
+	// 不继承宿主机的环境变量，避免 token、SSH agent 等信息泄露到容器中
+	cmd.Env = opts.Env
+	// 指定了 rootfs 时直接使用，否则从镜像创建 overlay 工作目录
+	if opts.Rootfs != "" {
+		cmd.Dir = opts.Rootfs
+		return cmd, writePipe
+	}
+	cmd.Dir = getMerged(opts.ContainerName)
+	if Rootless() {
+		if err = NewRootlessWorkSpace(opts.ImageName, opts.ContainerName); err != nil {
+			log.Errorf("NewRootlessWorkSpace error: %v", err)
+			return nil, nil
+		}
+		return cmd, writePipe
+	}
+	NewWorkSpace(opts.Volume, opts.ImageName, opts.ContainerName, opts.IDMappings)
+	if opts.IDMappings != nil {
+		cmd.Dir = UserNSRootfs(opts.ContainerName)
+	}
 	return cmd, writePipe
 }
@@ -0,0 +1,231 @@
+package container
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"mydocker/constant"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// DefaultShmSize 默认的 /dev/shm 大小，与 Docker 一致为 64MB
+const DefaultShmSize = 64 * 1024 * 1024
+
+// Device 容器 /dev 中的设备文件，Source 为宿主机上的路径，user namespace 中通过 bind mount 创建
+type Device struct {
+	Source      string      `json:"source"`
+	Path        string      `json:"path"`        // 容器内的路径
+	Type        rune        `json:"type"`        // c 为字符设备，b 为块设备
+	Major       int64       `json:"major"`       // 主设备号
+	Minor       int64       `json:"minor"`       // 次设备号
+	FileMode    os.FileMode `json:"fileMode"`    // 设备文件的权限
+	Permissions string      `json:"permissions"` // device cgroup 中的权限，r、w、m 的组合
+}
+
+// DefaultDevices 每个容器都有的设备
+var DefaultDevices = []Device{
+	{Source: "/dev/null", Path: "/dev/null", Type: 'c', Major: 1, Minor: 3, FileMode: 0666, Permissions: "rwm"},
+	{Source: "/dev/zero", Path: "/dev/zero", Type: 'c', Major: 1, Minor: 5, FileMode: 0666, Permissions: "rwm"},
+	{Source: "/dev/full", Path: "/dev/full", Type: 'c', Major: 1, Minor: 7, FileMode: 0666, Permissions: "rwm"},
+	{Source: "/dev/random", Path: "/dev/random", Type: 'c', Major: 1, Minor: 8, FileMode: 0666, Permissions: "rwm"},
+	{Source: "/dev/urandom", Path: "/dev/urandom", Type: 'c', Major: 1, Minor: 9, FileMode: 0666, Permissions: "rwm"},
+	{Source: "/dev/tty", Path: "/dev/tty", Type: 'c', Major: 5, Minor: 0, FileMode: 0666, Permissions: "rwm"},
+}
+
+// defaultDeviceRules 除了设备文件之外 device cgroup 中默认允许的规则
+var defaultDeviceRules = []string{
+	"c *:* m",      // 允许 mknod 任意字符设备，但是没有读写权限
+	"b *:* m",      // 允许 mknod 任意块设备，但是没有读写权限
+	"c 5:2 rwm",    // /dev/ptmx
+	"c 136:* rwm",  // /dev/pts/*
+	"c 10:200 rwm", // /dev/net/tun
+}
+
+// ParseDevice 解析 --device 参数，格式为 /dev/xyz[:path][:rwm]
+func ParseDevice(value string) (Device, error) {
+	parts := strings.Split(value, ":")
+	if len(parts) > 3 {
+		return Device{}, fmt.Errorf("invalid device %s, format should be /dev/xyz[:path][:rwm]", value)
+	}
+	source, path, permissions := parts[0], parts[0], "rwm"
+	switch len(parts) {
+	case 3:
+		path, permissions = parts[1], parts[2]
+	case 2:
+		// 第二段既可能是容器内的路径也可能是权限
+		if isDevicePermissions(parts[1]) {
+			permissions = parts[1]
+		} else {
+			path = parts[1]
+		}
+	}
+	if !isDevicePermissions(permissions) {
+		return Device{}, fmt.Errorf("invalid device permissions %s", permissions)
+	}
+	if !filepath.IsAbs(path) {
+		return Device{}, fmt.Errorf("device path %s must be absolute", path)
+	}
+	d, err := deviceFromPath(source, filepath.Clean(path))
+	if err != nil {
+		return Device{}, err
+	}
+	d.Permissions = permissions
+	return d, nil
+}
+
+func isDevicePermissions(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if !strings.ContainsRune("rwm", c) {
+			return false
+		}
+	}
+	return true
+}
+
+// deviceFromPath 读取宿主机设备文件的类型、设备号和权限
+func deviceFromPath(source, path string) (Device, error) {
+	var st unix.Stat_t
+	if err := unix.Stat(source, &st); err != nil {
+		return Device{}, errors.Wrapf(err, "stat device %s", source)
+	}
+	d := Device{
+		Source:   source,
+		Path:     path,
+		Major:    int64(unix.Major(st.Rdev)),
+		Minor:    int64(unix.Minor(st.Rdev)),
+		FileMode: os.FileMode(st.Mode & 0777),
+	}
+	switch st.Mode & unix.S_IFMT {
+	case unix.S_IFCHR:
+		d.Type = 'c'
+	case unix.S_IFBLK:
+		d.Type = 'b'
+	default:
+		return Device{}, fmt.Errorf("%s is not a device", source)
+	}
+	return d, nil
+}
+
+// HostDevices 宿主机 /dev 下的所有设备，privileged 容器使用
+func HostDevices() ([]Device, error) {
+	var devices []Device
+	err := filepath.Walk("/dev", func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			// 设备可能在遍历过程中被删除
+			return nil
+		}
+		if fi.IsDir() {
+			switch path {
+			case "/dev/pts", "/dev/shm", "/dev/mqueue", "/dev/fd":
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		// /dev/ptmx 是指向容器自己 devpts 的符号链接
+		if fi.Mode()&os.ModeDevice == 0 || path == "/dev/console" || path == "/dev/ptmx" {
+			return nil
+		}
+		d, err := deviceFromPath(path, path)
+		if err != nil {
+			return nil
+		}
+		d.Permissions = "rwm"
+		devices = append(devices, d)
+		return nil
+	})
+	return devices, errors.Wrap(err, "walk /dev")
+}
+
+// DeviceCgroupRules 生成 device cgroup 中允许的规则，其他设备一律禁止
+func DeviceCgroupRules(devices []Device) []string {
+	rules := append([]string{}, defaultDeviceRules...)
+	for _, d := range devices {
+		rules = append(rules, fmt.Sprintf("%c %d:%d %s", d.Type, d.Major, d.Minor, d.Permissions))
+	}
+	return rules
+}
+
+// setUpDev 在 rootfs 下创建私有的 /dev
+/*
+	/dev 是一个 tmpfs，只包含 spec.Devices 中的设备，宿主机的其他设备在容器中都看不到。
+	user namespace 中没有权限 mknod，设备文件改为从宿主机 bind mount。
+	pts 使用 newinstance 挂载一个独立的 devpts，/dev/ptmx 指向其中的 ptmx。
+*/
+func setUpDev(rootfs string, spec *InitSpec) error {
+	dev, err := secureJoin(rootfs, "/dev")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dev, constant.Perm0755); err != nil {
+		return errors.Wrapf(err, "mkdir %s", dev)
+	}
+	if err := syscall.Mount("tmpfs", dev, "tmpfs", syscall.MS_NOSUID|syscall.MS_STRICTATIME, "mode=755,size=65536k"); err != nil {
+		return errors.Wrap(err, "mount tmpfs on /dev")
+	}
+	for _, d := range spec.Devices {
+		if err := createDevice(rootfs, d, spec.UserNS); err != nil {
+			return err
+		}
+	}
+	links := [][2]string{
+		{"/proc/self/fd", "fd"},
+		{"/proc/self/fd/0", "stdin"},
+		{"/proc/self/fd/1", "stdout"},
+		{"/proc/self/fd/2", "stderr"},
+		{"pts/ptmx", "ptmx"},
+	}
+	for _, l := range links {
+		if err := os.Symlink(l[0], filepath.Join(dev, l[1])); err != nil {
+			return errors.Wrapf(err, "symlink /dev/%s", l[1])
+		}
+	}
+	// user namespace 中 tty 组不一定有映射，这时不指定 gid
+	ptsOptions := "newinstance,ptmxmode=0666,mode=0620"
+	if !spec.UserNS {
+		ptsOptions += ",gid=5"
+	}
+	pts := Mount{Source: "devpts", Destination: "/dev/pts", Type: "devpts", Options: []string{"nosuid", "noexec", ptsOptions}}
+	if err := mountTo(rootfs, pts); err != nil {
+		return err
+	}
+	shmSize := spec.ShmSize
+	if shmSize == 0 {
+		shmSize = DefaultShmSize
+	}
+	shm := Mount{Source: "shm", Destination: "/dev/shm", Type: "tmpfs",
+		Options: []string{"nosuid", "noexec", "nodev", "mode=1777", fmt.Sprintf("size=%d", shmSize)}}
+	return mountTo(rootfs, shm)
+}
+
+// createDevice 在 rootfs 中创建设备文件
+func createDevice(rootfs string, d Device, userns bool) error {
+	dest, err := secureJoin(rootfs, d.Path)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), constant.Perm0755); err != nil {
+		return errors.Wrapf(err, "mkdir %s", filepath.Dir(dest))
+	}
+	if userns {
+		return mountTo(rootfs, Mount{Source: d.Source, Destination: d.Path, Options: []string{"bind"}})
+	}
+	mode := uint32(d.FileMode)
+	if d.Type == 'b' {
+		mode |= unix.S_IFBLK
+	} else {
+		mode |= unix.S_IFCHR
+	}
+	if err := unix.Mknod(dest, mode, int(unix.Mkdev(uint32(d.Major), uint32(d.Minor)))); err != nil {
+		return errors.Wrapf(err, "mknod %s", d.Path)
+	}
+	// mknod 创建的权限会受 umask 影响，需要再 chmod 一次
+	return errors.Wrapf(os.Chmod(dest, d.FileMode), "chmod %s", d.Path)
+}
@@ -0,0 +1,118 @@
+package container
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultPath 容器内默认的 PATH
+const DefaultPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// ImageConfig 镜像的运行配置，和镜像 tar 包放在一起，比如 /root/busybox.json
+type ImageConfig struct {
+	Env []string `json:"env"` // 镜像默认的环境变量
+}
+
+// GetImageConfig 读取镜像配置，镜像没有配置文件时返回空配置
+func GetImageConfig(imageName string) (*ImageConfig, error) {
+	cfg := new(ImageConfig)
+	content, err := os.ReadFile(getImageConfig(imageName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return cfg, nil
+		}
+		return nil, errors.Wrapf(err, "read image %s config", imageName)
+	}
+	if err = json.Unmarshal(content, cfg); err != nil {
+		return nil, errors.Wrapf(err, "unmarshal image %s config", imageName)
+	}
+	return cfg, nil
+}
+
+// BuildEnv 生成容器进程的环境变量，不再继承宿主机的环境变量
+/*
+	优先级从低到高依次为：
+	1. 默认环境变量 PATH、HOSTNAME，以及 tty 模式下的 TERM
+	2. 镜像配置中的环境变量
+	3. --env-file 指定文件中的环境变量
+	4. -e 指定的环境变量，-e VAR 表示透传宿主机上的同名变量
+*/
+func BuildEnv(hostname string, tty bool, imageEnv, envFiles, envSlice []string) ([]string, error) {
+	env := []string{"PATH=" + DefaultPath, "HOSTNAME=" + hostname}
+	if tty {
+		env = append(env, "TERM=xterm")
+	}
+	env = mergeEnv(env, imageEnv)
+	for _, file := range envFiles {
+		fileEnv, err := ParseEnvFile(file)
+		if err != nil {
+			return nil, err
+		}
+		env = mergeEnv(env, fileEnv)
+	}
+	return mergeEnv(env, expandEnv(envSlice)), nil
+}
+
+// ParseEnvFile 解析 env 文件，每行一个 KEY=VALUE，# 开头的行为注释
+func ParseEnvFile(file string) ([]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "open env file %s", file)
+	}
+	defer f.Close()
+
+	var env []string
+	scanner := bufio.NewScanner(f)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "=") || strings.ContainsAny(strings.SplitN(line, "=", 2)[0], " \t") {
+			return nil, fmt.Errorf("env file %s line %d: invalid variable %q", file, lineNum, line)
+		}
+		env = append(env, line)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, errors.Wrapf(err, "read env file %s", file)
+	}
+	return expandEnv(env), nil
+}
+
+// expandEnv 将不带 = 的 VAR 替换为宿主机上的 VAR=value，宿主机上没有的变量直接忽略
+func expandEnv(env []string) []string {
+	result := make([]string, 0, len(env))
+	for _, kv := range env {
+		if strings.Contains(kv, "=") {
+			result = append(result, kv)
+			continue
+		}
+		if v, ok := os.LookupEnv(kv); ok {
+			result = append(result, kv+"="+v)
+		}
+	}
+	return result
+}
+
+// mergeEnv 用 override 覆盖 base 中同名的变量，保持变量第一次出现的顺序
+func mergeEnv(base, override []string) []string {
+	index := make(map[string]int, len(base))
+	result := make([]string, 0, len(base)+len(override))
+	for _, env := range [][]string{base, override} {
+		for _, kv := range env {
+			k, _, _ := strings.Cut(kv, "=")
+			if i, ok := index[k]; ok {
+				result[i] = kv
+				continue
+			}
+			index[k] = len(result)
+			result = append(result, kv)
+		}
+	}
+	return result
+}
@@ -0,0 +1,25 @@
+package container
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestBuildEnv(t *testing.T) {
+	t.Setenv("MYDOCKER_TEST_PASS", "host")
+	envFile := filepath.Join(t.TempDir(), "env")
+	if err := os.WriteFile(envFile, []byte("# comment\n\nFOO=file\nBAR=file\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	env, err := BuildEnv("abc", false, []string{"FOO=image"}, []string{envFile},
+		[]string{"BAR=flag", "MYDOCKER_TEST_PASS", "MYDOCKER_TEST_MISSING"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"PATH=" + DefaultPath, "HOSTNAME=abc", "FOO=file", "BAR=flag", "MYDOCKER_TEST_PASS=host"}
+	if !reflect.DeepEqual(env, want) {
+		t.Fatalf("got %q, want %q", env, want)
+	}
+}
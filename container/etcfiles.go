@@ -0,0 +1,165 @@
+package container
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"mydocker/constant"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	hostsFile      = "hosts"
+	resolvConfFile = "resolv.conf"
+	hostnameFile   = "hostname"
+
+	hostResolvConf = "/etc/resolv.conf"
+)
+
+// defaultNameservers 宿主机上只有本地 DNS 时容器使用的 DNS，与 Docker 一致
+var defaultNameservers = []string{"8.8.8.8", "8.8.4.4"}
+
+// EtcConfig 生成容器 /etc/hosts、/etc/resolv.conf 和 /etc/hostname 需要的信息
+type EtcConfig struct {
+	Hostname   string
+	Domainname string
+	IP         string   // 容器的 IP，没有网络时为空，hosts 中不会有 hostname 这一行
+	ExtraHosts []string // --add-host 指定的记录，格式为 host:ip
+	DNS        []string // 为空时使用宿主机 resolv.conf 中的 nameserver
+	DNSSearch  []string // 为空时使用宿主机 resolv.conf 中的 search
+	DNSOptions []string // 为空时使用宿主机 resolv.conf 中的 options
+
+	HostNetwork bool // 使用宿主机的 network namespace，可以访问宿主机上的本地 DNS
+}
+
+// EtcMounts 把容器信息目录下生成的文件 bind mount 到容器的 /etc 中
+/*
+	文件由 WriteEtcFiles 在网络配置好之后才写入，init 挂载时内容已经是完整的了。
+	bind mount 的是单独的文件，rootfs 只读时容器里也可以修改它们。
+*/
+func EtcMounts(containerName string) []Mount {
+	dir := fmt.Sprintf(InfoLocFormat, containerName)
+	var mounts []Mount
+	for _, name := range []string{hostsFile, resolvConfFile, hostnameFile} {
+		mounts = append(mounts, Mount{Source: dir + name, Destination: "/etc/" + name, Options: []string{"bind"}})
+	}
+	return mounts
+}
+
+// WriteEtcFiles 在容器信息目录下生成 hosts、resolv.conf 和 hostname
+func WriteEtcFiles(containerName string, c *EtcConfig) error {
+	dir := fmt.Sprintf(InfoLocFormat, containerName)
+	hosts, err := buildHosts(c)
+	if err != nil {
+		return err
+	}
+	hostResolv, err := os.ReadFile(hostResolvConf)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "read %s", hostResolvConf)
+	}
+	files := map[string][]byte{
+		hostsFile:      hosts,
+		resolvConfFile: buildResolvConf(hostResolv, c),
+		hostnameFile:   []byte(c.Hostname + "\n"),
+	}
+	for name, content := range files {
+		if err = os.WriteFile(dir+name, content, constant.Perm0644); err != nil {
+			return errors.Wrapf(err, "write %s", dir+name)
+		}
+	}
+	return nil
+}
+
+// ParseExtraHost 解析 --add-host，格式为 host:ip，IPv6 地址中的冒号不影响解析
+func ParseExtraHost(value string) (string, string, error) {
+	host, ip, ok := strings.Cut(value, ":")
+	if !ok || host == "" {
+		return "", "", fmt.Errorf("invalid add-host %s, format should be host:ip", value)
+	}
+	if net.ParseIP(ip) == nil {
+		return "", "", fmt.Errorf("invalid IP address %s in add-host %s", ip, value)
+	}
+	return host, ip, nil
+}
+
+// buildHosts 生成 hosts，依次为 localhost、--add-host 的记录和容器自己的 hostname
+func buildHosts(c *EtcConfig) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString("127.0.0.1\tlocalhost\n")
+	buf.WriteString("::1\tlocalhost ip6-localhost ip6-loopback\n")
+	buf.WriteString("fe00::0\tip6-localnet\n")
+	buf.WriteString("ff00::0\tip6-mcastprefix\n")
+	buf.WriteString("ff02::1\tip6-allnodes\n")
+	buf.WriteString("ff02::2\tip6-allrouters\n")
+	for _, value := range c.ExtraHosts {
+		host, ip, err := ParseExtraHost(value)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&buf, "%s\t%s\n", ip, host)
+	}
+	if c.IP != "" {
+		names := c.Hostname
+		if c.Domainname != "" {
+			names = c.Hostname + "." + c.Domainname + " " + c.Hostname
+		}
+		fmt.Fprintf(&buf, "%s\t%s\n", c.IP, names)
+	}
+	return buf.Bytes(), nil
+}
+
+// buildResolvConf 在宿主机 resolv.conf 的基础上用 --dns、--dns-search、--dns-option 覆盖对应的部分
+/*
+	容器有自己的 network namespace 时，宿主机上 127.0.0.53 这样的本地 DNS 在容器里访问不到，
+	需要去掉，去掉之后没有 nameserver 时使用 defaultNameservers。
+*/
+func buildResolvConf(hostResolv []byte, c *EtcConfig) []byte {
+	var nameservers, search, options []string
+	scanner := bufio.NewScanner(bytes.NewReader(hostResolv))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "nameserver":
+			if ip := net.ParseIP(fields[1]); ip != nil && (c.HostNetwork || !ip.IsLoopback()) {
+				nameservers = append(nameservers, fields[1])
+			}
+		case "search", "domain":
+			search = fields[1:]
+		case "options":
+			options = append(options, fields[1:]...)
+		}
+	}
+	if len(c.DNS) > 0 {
+		nameservers = c.DNS
+	}
+	if len(nameservers) == 0 {
+		nameservers = defaultNameservers
+	}
+	if len(c.DNSSearch) > 0 {
+		search = c.DNSSearch
+	}
+	if len(c.DNSOptions) > 0 {
+		options = c.DNSOptions
+	}
+
+	var buf bytes.Buffer
+	for _, ns := range nameservers {
+		fmt.Fprintf(&buf, "nameserver %s\n", ns)
+	}
+	// --dns-search . 表示不使用 search
+	if len(search) > 0 && !(len(search) == 1 && search[0] == ".") {
+		fmt.Fprintf(&buf, "search %s\n", strings.Join(search, " "))
+	}
+	if len(options) > 0 {
+		fmt.Fprintf(&buf, "options %s\n", strings.Join(options, " "))
+	}
+	return buf.Bytes()
+}
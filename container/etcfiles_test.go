@@ -0,0 +1,54 @@
+package container
+
+import "testing"
+
+func TestBuildHosts(t *testing.T) {
+	got, err := buildHosts(&EtcConfig{
+		Hostname:   "web",
+		Domainname: "example.com",
+		IP:         "192.168.0.2",
+		ExtraHosts: []string{"db:10.0.0.5", "v6:fd00::1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "127.0.0.1\tlocalhost\n" +
+		"::1\tlocalhost ip6-localhost ip6-loopback\n" +
+		"fe00::0\tip6-localnet\n" +
+		"ff00::0\tip6-mcastprefix\n" +
+		"ff02::1\tip6-allnodes\n" +
+		"ff02::2\tip6-allrouters\n" +
+		"10.0.0.5\tdb\n" +
+		"fd00::1\tv6\n" +
+		"192.168.0.2\tweb.example.com web\n"
+	if string(got) != want {
+		t.Errorf("buildHosts = %q, want %q", got, want)
+	}
+	for _, value := range []string{"db", ":1.2.3.4", "db:foo"} {
+		if _, err = buildHosts(&EtcConfig{ExtraHosts: []string{value}}); err == nil {
+			t.Errorf("buildHosts with add-host %q expect error", value)
+		}
+	}
+}
+
+func TestBuildResolvConf(t *testing.T) {
+	host := []byte("# comment\nnameserver 127.0.0.53\nnameserver 10.0.0.1\nsearch a.com b.com\noptions edns0\n")
+	cases := []struct {
+		config EtcConfig
+		want   string
+	}{
+		{EtcConfig{}, "nameserver 10.0.0.1\nsearch a.com b.com\noptions edns0\n"},
+		{EtcConfig{DNS: []string{"1.1.1.1"}, DNSSearch: []string{"."}, DNSOptions: []string{"ndots:2"}}, "nameserver 1.1.1.1\noptions ndots:2\n"},
+		{EtcConfig{HostNetwork: true}, "nameserver 127.0.0.53\nnameserver 10.0.0.1\nsearch a.com b.com\noptions edns0\n"},
+	}
+	for _, c := range cases {
+		if got := string(buildResolvConf(host, &c.config)); got != c.want {
+			t.Errorf("buildResolvConf(%+v) = %q, want %q", c.config, got, c.want)
+		}
+	}
+	// 宿主机只有本地 DNS 时使用默认 DNS
+	want := "nameserver 8.8.8.8\nnameserver 8.8.4.4\n"
+	if got := string(buildResolvConf([]byte("nameserver 127.0.0.53\n"), &EtcConfig{})); got != want {
+		t.Errorf("buildResolvConf with only local DNS = %q, want %q", got, want)
+	}
+}
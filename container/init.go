@@ -4,13 +4,16 @@ import (
 	"fmt"
 	"io"
 	"mydocker/constant"
+	"mydocker/seccomp"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"syscall"
 
 	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -22,32 +25,240 @@ import (
 	使用 mount 先去挂载 proc 文件系统，以便后面通过 ps 等系统命令去查看当前进程资源的情况。
 */
 func RunContainerInitProcess() error {
-	// 通过 readPipe 读取 writePipe 写入的需要执行的命令
-	cmdList := readUserCommand()
-	if len(cmdList) == 0 {
-		return errors.New("run container get user command error, cmdList is nil")
+	// 通过 readPipe 读取父进程写入的 InitSpec
+	spec, err := readInitSpec()
+	if err != nil {
+		return errors.Wrap(err, "run container get init spec error")
+	}
+	if spec.UserNS {
+		if err = reexecInUserNS(spec); err != nil {
+			return err
+		}
 	}
 
+	// unshare 只改变当前线程的 namespace，之后的挂载和 execve 都必须在同一个线程中执行
+	runtime.LockOSThread()
+	// 父进程在发送 InitSpec 之前已经把 init 加入了容器的 cgroup，这时创建的 cgroup namespace 以容器的 cgroup 为根
+	if spec.CgroupNS {
+		if err = unix.Unshare(unix.CLONE_NEWCGROUP); err != nil {
+			return errors.Wrap(err, "unshare cgroup namespace")
+		}
+	}
+	if spec.TimeOffsets != nil {
+		if err = setUpTimeNamespace(spec.TimeOffsets); err != nil {
+			return err
+		}
+	}
 	// 挂载文件系统
-	setUpMount()
+	if err = setUpMount(spec); err != nil {
+		return err
+	}
+	// 设置容器内部 hostname
+	if spec.Hostname != "" {
+		if err = syscall.Sethostname([]byte(spec.Hostname)); err != nil {
+			return errors.Wrap(err, "set hostname")
+		}
+	}
+	if spec.Domainname != "" {
+		if err = syscall.Setdomainname([]byte(spec.Domainname)); err != nil {
+			return errors.Wrap(err, "set domainname")
+		}
+	}
+	// 父进程在发送 InitSpec 之前已经配置好了网络，这时网卡都已经存在
+	if err = setSysctls(spec.Sysctl); err != nil {
+		return err
+	}
+	// 工作目录不存在时自动创建，必须在 rootfs 变成只读之前
+	if spec.Cwd != "" {
+		if err = os.MkdirAll(spec.Cwd, constant.Perm0755); err != nil {
+			return errors.Wrapf(err, "create working directory %s", spec.Cwd)
+		}
+	}
+	if err = finalizeRootfs(spec); err != nil {
+		return err
+	}
+	return execUserProcess(spec)
+}
+
+// RunContainerExecProcess 执行 exec 命令的用户进程
+/*
+	nsenter 的 C 代码已经进入了容器的 namespace 并 fork 出当前进程，这里不需要再挂载文件系统，
+	只需要和 init 一样设置环境变量、用户、capability 和 seccomp 之后执行用户命令。
+*/
+func RunContainerExecProcess() error {
+	spec, err := readInitSpec()
+	if err != nil {
+		return errors.Wrap(err, "exec get init spec error")
+	}
+	return execUserProcess(spec)
+}
+
+// execUserProcess 完成用户进程执行前的最后设置，然后 exec 用户命令
+/*
+	seccomp 的安装和 capability 的设置有先后依赖：
+	1. 收缩 bounding set 需要 CAP_SETPCAP，切换用户需要 CAP_SETUID 和 CAP_SETGID，所以要最先做
+	2. 没有设置 no_new_privs 时安装 seccomp 需要 CAP_SYS_ADMIN，因此先临时保留 CAP_SYS_ADMIN 安装 seccomp
+	3. 最后再设置最终的 capability，seccomp 之后只剩下 capset、prctl 和 execve 几个系统调用
+*/
+func execUserProcess(spec *InitSpec) error {
+	// capability、keepcaps 和 bounding set 都是线程级别的，必须在同一个线程中设置并执行 execve
+	runtime.LockOSThread()
+	err := setEnv(spec.Env)
+	if err != nil {
+		return err
+	}
+	// 用户和组要在容器自己的 /etc/passwd 和 /etc/group 中解析
+	user, err := resolveUser(spec.User, spec.AdditionalGroups, passwdPath, groupPath)
+	if err != nil {
+		return err
+	}
+	if _, ok := os.LookupEnv("HOME"); !ok {
+		_ = os.Setenv("HOME", user.Home)
+	}
+	if spec.Cwd != "" {
+		if err = os.Chdir(spec.Cwd); err != nil {
+			return errors.Wrapf(err, "chdir %s", spec.Cwd)
+		}
+	}
 
-	path, err := exec.LookPath(cmdList[0])
+	// 这里的 PATH 已经是容器内的环境变量了
+	path, err := exec.LookPath(spec.Args[0])
 	if err != nil {
 		log.Errorf("Exec loop path error: %v", err)
 		return err
 	}
-	// 设置容器内部 hostname
-	// syscall.Sethostname([]byte("container"))
 	log.Infof("Find path %s", path)
-	if err = syscall.Exec(path, cmdList[0:], os.Environ()); err != nil {
+	if spec.ExecFifo {
+		if err = waitStart(); err != nil {
+			return err
+		}
+	}
+	// 提高 hard limit 需要 CAP_SYS_RESOURCE，必须在切换用户和收缩 capability 之前设置
+	if err = setRlimits(spec.Rlimits); err != nil {
+		return err
+	}
+
+	caps, err := newCapSet(spec.Capabilities)
+	if err != nil {
+		return err
+	}
+	if err = caps.dropBounding(); err != nil {
+		return err
+	}
+	// 切换到非 root 用户时保留 permitted set，否则 setuid 之后 capability 会被全部清空
+	if err = unix.Prctl(unix.PR_SET_KEEPCAPS, 1, 0, 0, 0); err != nil {
+		return errors.Wrap(err, "set keep capabilities")
+	}
+	if err = setUser(user); err != nil {
+		return err
+	}
+	_, permitted, _, err := capget()
+	if err != nil {
+		return err
+	}
+	if err = caps.apply(permitted & (1 << unix.CAP_SYS_ADMIN)); err != nil {
+		return err
+	}
+	if spec.NoNewPrivileges {
+		if err = unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+			return errors.Wrap(err, "set no new privileges")
+		}
+	}
+	// 没有 no_new_privs 时安装 seccomp 需要 CAP_SYS_ADMIN，只能在收缩 capability 之前安装
+	early := seccompBeforeCaps(spec)
+	if early {
+		if err = seccomp.Install(spec.Seccomp, seccompCaps(spec)); err != nil {
+			return err
+		}
+	}
+	if err = caps.apply(0); err != nil {
+		return err
+	}
+	if err = caps.raiseAmbient(); err != nil {
+		return err
+	}
+	// 和 runc 一样在 exec 之前才安装 seccomp，profile 只需要允许 execve
+	if !early {
+		if err = seccomp.Install(spec.Seccomp, seccompCaps(spec)); err != nil {
+			return err
+		}
+	}
+	if spec.Init {
+		return runAsInit(path, spec.Args)
+	}
+	if err = syscall.Exec(path, spec.Args, os.Environ()); err != nil {
 		log.Errorf("RunContainerInitProcess exec :" + err.Error())
 	}
+	return err
+}
+
+// seccompCaps 用户进程最终拥有的 capability，用来判断 profile 中的 includes.caps 和 excludes.caps
+func seccompCaps(spec *InitSpec) []string {
+	if spec.Capabilities != nil {
+		return spec.Capabilities.Effective
+	}
+	return AllCapabilities()
+}
+
+// seccompBeforeCaps 判断是否需要在收缩 capability 之前安装 seccomp
+/*
+	内核要求安装过滤器的进程设置了 no_new_privs 或者拥有 CAP_SYS_ADMIN，
+	两者都没有时只能趁 init 还保留着 CAP_SYS_ADMIN 的时候安装。
+*/
+func seccompBeforeCaps(spec *InitSpec) bool {
+	if spec.NoNewPrivileges {
+		return false
+	}
+	for _, c := range seccompCaps(spec) {
+		if c == "CAP_SYS_ADMIN" {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckSeccomp 检查 profile 是否允许 init 在安装 seccomp 之后还要用到的系统调用
+/*
+	安装之后 init 还要执行 execve，在收缩 capability 之前安装时还要调用 capset 和 prctl，
+	默认拒绝的 profile 没有允许它们时容器无法启动，这里提前给出明确的错误。
+*/
+func CheckSeccomp(spec *InitSpec) error {
+	if spec.Seccomp == nil {
+		return nil
+	}
+	required := []string{"execve"}
+	if seccompBeforeCaps(spec) {
+		required = append(required, "capset", "prctl")
+	}
+	for _, name := range required {
+		if !spec.Seccomp.Allows(name, seccompCaps(spec)) {
+			return fmt.Errorf("seccomp profile must allow %s, mydocker init calls it after the filter is installed", name)
+		}
+	}
 	return nil
 }
 
-const fdIndex = 3
+const (
+	fdIndex     = 3
+	execFifoFd  = fdIndex + 1
+	execFifoMsg = "0"
+)
 
-func readUserCommand() []string {
+// waitStart 以写方式打开父进程传过来的 exec.fifo，没有读者时 open 会一直阻塞，直到执行 start 命令
+func waitStart() error {
+	// fifo 在宿主机上的路径在 pivot_root 之后已经看不到了，通过 /proc/self/fd 重新打开 O_PATH 的 fd
+	fifo, err := os.OpenFile(fmt.Sprintf("/proc/self/fd/%d", execFifoFd), os.O_WRONLY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return errors.Wrap(err, "open exec fifo")
+	}
+	defer fifo.Close()
+	if _, err = fifo.WriteString(execFifoMsg); err != nil {
+		return errors.Wrap(err, "write exec fifo")
+	}
+	return syscall.Close(execFifoFd)
+}
+
+func readInitSpec() (*InitSpec, error) {
 	// uintptr(3) 就是指 index 为 3 的文件描述符，也就是传递进来的管道的另一端，至于为什么是 3，具体解释如下：
 	/*
 		因为每个进程默认都会有3个文件描述符，分别是标准输入、标准输出、标准错误。这3个是子进程一创建的时候就会默认带着的，
@@ -63,28 +274,161 @@ func readUserCommand() []string {
 	*/
 	pipe := os.NewFile(uintptr(fdIndex), "pipe")
 	defer pipe.Close()
-	msg, err := io.ReadAll(pipe)
-	if err != nil {
-		log.Errorf("init read pipe error: %v", err)
+	return ReadInitSpec(pipe)
+}
+
+// reexecInUserNS 在 id 映射写入之后重新执行自己，以获得容器 user namespace 中的全部 capability
+/*
+	非 root 用户通过 newuidmap 写入 id 映射时 init 已经 exec 过了，exec 时进程的 uid 还没有映射，
+	capability 全部被清空。映射写好之后（父进程在发送 InitSpec 之前写入）uid 已经变成了容器内的 0，
+	此时再 exec 一次就能重新拿到所有 capability。已经读出来的 InitSpec 通过 memfd 重新放到 fd 3 上。
+*/
+func reexecInUserNS(spec *InitSpec) error {
+	hdr := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+	var data [2]unix.CapUserData
+	if err := unix.Capget(&hdr, &data[0]); err != nil {
+		return errors.Wrap(err, "capget")
+	}
+	if data[0].Effective&(1<<unix.CAP_SYS_ADMIN) != 0 {
 		return nil
 	}
-	msgStr := string(msg)
-	return strings.Split(msgStr, " ")
+	// 映射没有写入时 uid 为 overflow uid，此时再 exec 也拿不到 capability
+	if os.Getuid() != 0 {
+		return fmt.Errorf("uid %d is not mapped to root in user namespace", os.Getuid())
+	}
+	fd, err := unix.MemfdCreate("init-spec", 0)
+	if err != nil {
+		return errors.Wrap(err, "memfd create")
+	}
+	memfd := os.NewFile(uintptr(fd), "init-spec")
+	if err = WriteInitSpec(memfd, spec); err != nil {
+		return err
+	}
+	if _, err = memfd.Seek(0, io.SeekStart); err != nil {
+		return errors.Wrap(err, "seek init spec")
+	}
+	// 读取 InitSpec 之后 fd 3 已经关闭，memfd 可能正好就是 fd 3
+	if fd != fdIndex {
+		if err = unix.Dup3(fd, fdIndex, 0); err != nil {
+			return errors.Wrap(err, "dup init spec")
+		}
+	}
+	log.Infof("re-exec init to gain capabilities in user namespace")
+	return errors.Wrap(syscall.Exec("/proc/self/exe", os.Args, os.Environ()), "re-exec init")
+}
+
+// rlimitTypes 资源限制名称到 setrlimit 资源编号的映射
+var rlimitTypes = map[string]int{
+	"RLIMIT_CPU":        unix.RLIMIT_CPU,
+	"RLIMIT_FSIZE":      unix.RLIMIT_FSIZE,
+	"RLIMIT_DATA":       unix.RLIMIT_DATA,
+	"RLIMIT_STACK":      unix.RLIMIT_STACK,
+	"RLIMIT_CORE":       unix.RLIMIT_CORE,
+	"RLIMIT_RSS":        unix.RLIMIT_RSS,
+	"RLIMIT_NPROC":      unix.RLIMIT_NPROC,
+	"RLIMIT_NOFILE":     unix.RLIMIT_NOFILE,
+	"RLIMIT_MEMLOCK":    unix.RLIMIT_MEMLOCK,
+	"RLIMIT_AS":         unix.RLIMIT_AS,
+	"RLIMIT_LOCKS":      unix.RLIMIT_LOCKS,
+	"RLIMIT_SIGPENDING": unix.RLIMIT_SIGPENDING,
+	"RLIMIT_MSGQUEUE":   unix.RLIMIT_MSGQUEUE,
+	"RLIMIT_NICE":       unix.RLIMIT_NICE,
+	"RLIMIT_RTPRIO":     unix.RLIMIT_RTPRIO,
+	"RLIMIT_RTTIME":     unix.RLIMIT_RTTIME,
+}
+
+func setRlimits(rlimits []Rlimit) error {
+	for _, r := range rlimits {
+		resource, ok := rlimitTypes[r.Type]
+		if !ok {
+			return fmt.Errorf("unknown rlimit type %s", r.Type)
+		}
+		if err := unix.Prlimit(0, resource, &unix.Rlimit{Cur: r.Soft, Max: r.Hard}, nil); err != nil {
+			return errors.Wrapf(err, "set rlimit %s", r.Type)
+		}
+	}
+	return nil
+}
+
+// setEnv 用 spec 中的环境变量替换 init 进程自身的环境变量
+func setEnv(env []string) error {
+	os.Clearenv()
+	for _, kv := range env {
+		k, v, _ := strings.Cut(kv, "=")
+		if err := os.Setenv(k, v); err != nil {
+			return errors.Wrapf(err, "set env %s", kv)
+		}
+	}
+	return nil
+}
+
+// setUser 切换到解析好的用户、组和附加组
+/*
+	user namespace 的 gid 映射不是由特权进程写入时 /proc/self/setgroups 为 deny，
+	这时无法调用 setgroups，没有附加组时跳过，有附加组时报错。
+*/
+func setUser(u *execUser) error {
+	content, _ := os.ReadFile("/proc/self/setgroups")
+	if strings.TrimSpace(string(content)) == "deny" {
+		if len(u.Sgids) > 0 {
+			return errors.New("additional groups are not supported because setgroups is denied in the user namespace")
+		}
+	} else if err := syscall.Setgroups(u.Sgids); err != nil {
+		return errors.Wrap(err, "setgroups")
+	}
+	if err := syscall.Setgid(u.Gid); err != nil {
+		return errors.Wrap(err, "setgid")
+	}
+	return errors.Wrap(syscall.Setuid(u.Uid), "setuid")
 }
 
 // Init 挂载点
-func setUpMount() {
-	pwd, err := os.Getwd()
-	// fmt.Println("PWD: " + pwd)
-	if err != nil {
-		log.Errorf("get current location error: %v", err)
-		return
+func setUpMount(spec *InitSpec) error {
+	rootfs := spec.Rootfs
+	if rootfs == "" {
+		pwd, err := os.Getwd()
+		if err != nil {
+			return errors.Wrap(err, "get current location")
+		}
+		rootfs = pwd
 	}
-	log.Infof("current location is: %s", pwd)
+	log.Infof("current location is: %s", rootfs)
 
-	syscall.Mount("", "/", "", syscall.MS_PRIVATE|syscall.MS_REC, "")
-	pivotRoot(pwd)
-	// fmt.Println(os.Getwd())
+	if err := syscall.Mount("", "/", "", syscall.MS_PRIVATE|syscall.MS_REC, ""); err != nil {
+		return errors.Wrap(err, "make / private")
+	}
+	// rootless 模式下宿主机上无法挂载 overlayfs，由 init 在自己的 user namespace 中挂载
+	if m := spec.RootfsMount; m != nil {
+		if err := mountTo(rootfs, *m); err != nil {
+			return err
+		}
+	}
+	// pivot_root 要求 new_root 是一个挂载点，OCI bundle 的 rootfs 只是一个普通目录，
+	// 所以这里统一把 rootfs bind mount 到自己身上
+	if err := syscall.Mount(rootfs, rootfs, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		return errors.Wrap(err, "mount rootfs to itself")
+	}
+	// 设备文件在 user namespace 中需要从宿主机 bind mount，所以也要在 pivot_root 之前创建
+	if err := setUpDev(rootfs, spec); err != nil {
+		return err
+	}
+	// pivot_root 之前宿主机上的路径还能访问，因此额外的挂载点需要在这里挂载
+	for _, m := range spec.Mounts {
+		if err := mountTo(rootfs, m); err != nil {
+			return err
+		}
+	}
+	if spec.UserNS {
+		// user namespace 中只有 mount namespace 里还能看到完整的 proc 时才允许挂载新的 proc，
+		// 所以要在卸载老的 root 之前挂载
+		proc := Mount{Source: "proc", Destination: "/proc", Type: "proc", Options: []string{"nosuid", "noexec", "nodev"}}
+		if err := mountTo(rootfs, proc); err != nil {
+			return err
+		}
+	}
+	if err := pivotRoot(rootfs); err != nil {
+		return err
+	}
 
 	// Mount /proc
 	defaultMountFlags := syscall.MS_NOEXEC | syscall.MS_NOSUID | syscall.MS_NODEV
@@ -93,13 +437,7 @@ func setUpMount() {
 	syscall.Mount("", "/proc", "", syscall.MS_PRIVATE|syscall.MS_REC, "")
 	// mount -t proc proc /proc
 	syscall.Mount("proc", "/proc", "proc", uintptr(defaultMountFlags), "")
-
-	// Mount tmpfs
-	syscall.Mount("", "/dev", "", syscall.MS_PRIVATE|syscall.MS_REC, "")
-	syscall.Mount("none", "/dev", "devtmpfs", syscall.MS_NOSUID|syscall.MS_STRICTATIME, "mode=755")
-	// syscall.Mount("devpts", "/dev/pts", "devpts", syscall.MS_NOEXEC|syscall.MS_NOSUID, "newinstance,ptmxmode=0666")
-	// syscall.Mknod("/dev/ptmx", syscall.S_IFCHR, int(unix.Mkdev(5, 2)))
-	// syscall.Setsid()
+	return nil
 }
 
 func pivotRoot(rootPath string) error {
@@ -107,16 +445,12 @@ func pivotRoot(rootPath string) error {
 		为了使当前 root 的老 root 和新 root 不在同一个文件系统下，我们把 root 重新 mount 了一次
 		bind mount 是把相同的内容换了一个挂载点的挂载方法
 	*/
-	// pivotRoot 要求 newroot 是一个挂载点，但是在前面的时候已经通过 mount overlayfs 挂载过了
-	// 所以不需要再次挂载，否则会重复挂载
-	// if err := syscall.Mount(rootPath, rootPath, "", syscall.MS_SLAVE|syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
-	// 	return errors.Wrap(err, "mount rootfs to itself")
-	// }
+	// pivotRoot 要求 newroot 是一个挂载点，setUpMount 中已经把 rootfs bind mount 到自己身上了
 	// 创建 rootfs/.old_root 来存储 old root
-	// 不知道为什么，.old_root 会存在，创建不了，故修改为 .old_root1
-	oldDir := filepath.Join(rootPath, ".old_root1")
-	if err := os.Mkdir(oldDir, constant.Perm0777); err != nil {
-		return fmt.Errorf("mkdir %s error: %v", oldDir, err)
+	// 多个容器可能共用同一个 rootfs（比如 OCI bundle），所以每次都创建一个随机名字的目录
+	oldDir, err := os.MkdirTemp(rootPath, ".old_root")
+	if err != nil {
+		return fmt.Errorf("mkdir old root error: %v", err)
 	}
 	// 系统调用 pivot_root 切换到新的 root，将老的 root 挂载到 rootfs/.old_root 下
 	if err := syscall.PivotRoot(rootPath, oldDir); err != nil {
@@ -127,7 +461,7 @@ func pivotRoot(rootPath string) error {
 		return fmt.Errorf("chdir %v", err)
 	}
 
-	oldDir = filepath.Join("/", ".old_root1")
+	oldDir = filepath.Join("/", filepath.Base(oldDir))
 	// unmount rootfs/.old_root
 	if err := syscall.Unmount(oldDir, syscall.MNT_DETACH); err != nil {
 		return fmt.Errorf("unmount old_root dir error: %v", err)
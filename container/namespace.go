@@ -0,0 +1,108 @@
+package container
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"syscall"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// namespaceNames clone flag 对应的 /proc/<pid>/ns/ 下的文件名，这几种 namespace 可以和宿主机或其他容器共享
+var namespaceNames = map[uintptr]string{
+	syscall.CLONE_NEWNET: "net",
+	syscall.CLONE_NEWIPC: "ipc",
+	syscall.CLONE_NEWPID: "pid",
+	syscall.CLONE_NEWUTS: "uts",
+}
+
+// NamespacePath 容器要加入的已有 namespace
+type NamespacePath struct {
+	Flag uintptr // namespace 对应的 clone flag
+	Path string  // namespace 文件的路径，比如 /proc/<pid>/ns/net
+}
+
+// NewNamespacePath 返回进程 pid 所在的 flag 类型的 namespace
+func NewNamespacePath(pid string, flag uintptr) NamespacePath {
+	return NamespacePath{Flag: flag, Path: fmt.Sprintf("/proc/%s/ns/%s", pid, namespaceNames[flag])}
+}
+
+// JoinNamespaces 在当前线程加入 namespaces 之后执行 fn，返回前再回到原来的 namespace
+/*
+	clone 出的子进程继承的是调用线程的 namespace，所以 fn 中启动的容器进程直接运行在这些 namespace 中，
+	不需要再创建新的。net、ipc、uts 和 pid namespace 都允许多线程的进程通过 setns 加入，
+	其中 pid namespace 只对之后创建的子进程生效。
+*/
+func JoinNamespaces(namespaces []NamespacePath, fn func() error) error {
+	if len(namespaces) == 0 {
+		return fn()
+	}
+	// setns 只改变当前线程的 namespace，执行期间不能让 goroutine 切换到其他线程
+	runtime.LockOSThread()
+	// 加入之前先打开当前线程原来的 namespace，之后才能回去
+	var origins []*os.File
+	defer func() {
+		for _, f := range origins {
+			f.Close()
+		}
+	}()
+	err := func() error {
+		for _, ns := range namespaces {
+			name, ok := namespaceNames[ns.Flag]
+			if !ok {
+				return fmt.Errorf("joining namespace with clone flag %#x is not supported", ns.Flag)
+			}
+			origin, err := os.Open("/proc/thread-self/ns/" + name)
+			if err != nil {
+				return errors.Wrapf(err, "open current %s namespace", name)
+			}
+			if err = setns(ns.Path, ns.Flag); err != nil {
+				origin.Close()
+				return err
+			}
+			origins = append(origins, origin)
+		}
+		return fn()
+	}()
+	for i := len(origins) - 1; i >= 0; i-- {
+		if restoreErr := unix.Setns(int(origins[i].Fd()), int(namespaces[i].Flag)); restoreErr != nil {
+			// 回不去的线程不能再给其他 goroutine 使用，保持锁定
+			log.Errorf("Restore %s namespace error %v", namespaceNames[namespaces[i].Flag], restoreErr)
+			return err
+		}
+	}
+	runtime.UnlockOSThread()
+	return err
+}
+
+// NamespaceHostname 返回 namespaces 中 UTS namespace 的 hostname，没有 UTS namespace 时返回宿主机的 hostname
+func NamespaceHostname(namespaces []NamespacePath) (string, error) {
+	var uts []NamespacePath
+	for _, ns := range namespaces {
+		if ns.Flag == syscall.CLONE_NEWUTS {
+			uts = append(uts, ns)
+		}
+	}
+	var hostname string
+	err := JoinNamespaces(uts, func() error {
+		var name unix.Utsname
+		if err := unix.Uname(&name); err != nil {
+			return errors.Wrap(err, "uname")
+		}
+		hostname = unix.ByteSliceToString(name.Nodename[:])
+		return nil
+	})
+	return hostname, err
+}
+
+func setns(path string, flag uintptr) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return errors.Wrapf(err, "open %s", path)
+	}
+	defer f.Close()
+	return errors.Wrapf(unix.Setns(int(f.Fd()), int(flag)), "setns %s", path)
+}
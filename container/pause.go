@@ -0,0 +1,40 @@
+package container
+
+import (
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// PodCloneflags pod 中的容器共享的 namespace，由 pause 进程创建并持有
+const PodCloneflags = syscall.CLONE_NEWNET | syscall.CLONE_NEWIPC | syscall.CLONE_NEWUTS
+
+// NewPauseProcess 构建 pod 的 pause 进程
+/*
+	pause 进程在新的 network、IPC 和 UTS namespace 中运行，只是为了让这些 namespace 在 pod 中没有容器时也一直存在，
+	pod 中的容器都会加入它的 namespace。
+*/
+func NewPauseProcess() (*exec.Cmd, error) {
+	pauseCmd, err := os.Readlink("/proc/self/exe")
+	if err != nil {
+		return nil, errors.Wrap(err, "get pause process")
+	}
+	cmd := exec.Command(pauseCmd, "pod-pause")
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags: PodCloneflags,
+		Setsid:     true,
+	}
+	cmd.Env = []string{}
+	return cmd, nil
+}
+
+// RunPauseProcess pause 进程什么都不做，直到收到 SIGTERM 或 SIGINT 才退出
+func RunPauseProcess() error {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	<-signals
+	return nil
+}
@@ -0,0 +1,80 @@
+package container
+
+import (
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// runAsInit 以 PID 1 的身份运行用户命令，用于 --init
+/*
+	用户进程直接作为 PID 1 时有两个问题：
+	1. 内核不会给 PID 1 安装默认的信号处理，没有自己处理 SIGTERM 的程序 stop 时只能被 SIGKILL 杀死
+	2. 孤儿进程都会被交给 PID 1，shell 脚本这样的程序不会 wait 它们，容器里就会留下僵尸进程
+	因此 init 在完成所有设置之后不再 exec，而是 fork 出用户进程，把收到的信号都转发给它，
+	并回收所有退出的子进程，用户进程退出后 init 以相同的状态退出。
+*/
+func runAsInit(path string, args []string) error {
+	signals := make(chan os.Signal, 32)
+	// 不指定信号时会接收所有信号，必须在启动子进程之前注册，否则可能错过子进程的 SIGCHLD
+	signal.Notify(signals)
+
+	cmd := exec.Command(path)
+	cmd.Args = args
+	cmd.Env = os.Environ()
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return errors.Wrapf(err, "start %s", path)
+	}
+	child := cmd.Process.Pid
+	log.Infof("init started user process %d", child)
+
+	for sig := range signals {
+		switch sig {
+		case syscall.SIGCHLD:
+			if status, exited := reap(child); exited {
+				os.Exit(exitCode(status))
+			}
+		case syscall.SIGURG:
+			// Go 运行时用 SIGURG 做 goroutine 抢占，不是发给容器的信号
+		default:
+			if err := syscall.Kill(child, sig.(syscall.Signal)); err != nil && err != syscall.ESRCH {
+				log.Warnf("forward signal %v to %d error %v", sig, child, err)
+			}
+		}
+	}
+	return nil
+}
+
+// reap 回收所有已经退出的子进程，返回用户进程的退出状态以及它是否已经退出
+func reap(child int) (syscall.WaitStatus, bool) {
+	var childStatus syscall.WaitStatus
+	exited := false
+	for {
+		var status syscall.WaitStatus
+		pid, err := syscall.Wait4(-1, &status, syscall.WNOHANG, nil)
+		if err == syscall.EINTR {
+			continue
+		}
+		if pid <= 0 || err != nil {
+			return childStatus, exited
+		}
+		if pid == child {
+			childStatus, exited = status, true
+		}
+	}
+}
+
+// exitCode 和 shell 一样，被信号杀死时退出码为 128 加上信号编号
+func exitCode(status syscall.WaitStatus) int {
+	if status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return status.ExitStatus()
+}
@@ -0,0 +1,115 @@
+package container
+
+import (
+	"os"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// DefaultMaskedPaths 默认遮住的路径，与 Docker 一致，这些文件会泄露宿主机的信息或者可以用来攻击内核
+var DefaultMaskedPaths = []string{
+	"/proc/asound",
+	"/proc/acpi",
+	"/proc/kcore",
+	"/proc/keys",
+	"/proc/latency_stats",
+	"/proc/timer_list",
+	"/proc/timer_stats",
+	"/proc/sched_debug",
+	"/proc/scsi",
+	"/sys/firmware",
+	"/sys/devices/virtual/powercap",
+}
+
+// DefaultReadonlyPaths 默认只读的路径，通过它们可以修改宿主机内核的配置
+var DefaultReadonlyPaths = []string{
+	"/proc/bus",
+	"/proc/fs",
+	"/proc/irq",
+	"/proc/sys",
+	"/proc/sysrq-trigger",
+	cgroupRoot,
+}
+
+// SysfsMount 容器的 /sys，privileged 容器可写
+func SysfsMount(readonly bool) Mount {
+	options := []string{"nosuid", "noexec", "nodev"}
+	if readonly {
+		options = append(options, "ro")
+	}
+	return Mount{Source: "sysfs", Destination: "/sys", Type: "sysfs", Options: options}
+}
+
+// ReadonlyRootfsMounts 只读 rootfs 时仍然需要可写的目录
+func ReadonlyRootfsMounts() []Mount {
+	return []Mount{
+		{Source: "tmpfs", Destination: "/tmp", Type: "tmpfs", Options: []string{"nosuid", "nodev", "mode=1777"}},
+		{Source: "tmpfs", Destination: "/run", Type: "tmpfs", Options: []string{"nosuid", "nodev", "mode=755"}},
+	}
+}
+
+// finalizeRootfs 在 pivot_root 并挂载好 /proc 之后遮住敏感路径，并把需要的路径改为只读
+/*
+	只读必须最后做：/proc/sys 只读之后就不能再设置 sysctl，rootfs 只读之后也不能再创建挂载点了。
+*/
+func finalizeRootfs(spec *InitSpec) error {
+	for _, path := range spec.MaskedPaths {
+		if err := maskPath(path); err != nil {
+			return err
+		}
+	}
+	for _, path := range spec.ReadonlyPaths {
+		if err := readonlyPath(path); err != nil {
+			return err
+		}
+	}
+	if spec.ReadonlyRootfs {
+		return remountReadonly("/")
+	}
+	return nil
+}
+
+// maskPath 目录挂载一个只读的空 tmpfs，文件则 bind mount /dev/null，路径不存在时忽略
+func maskPath(path string) error {
+	fi, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "stat %s", path)
+	}
+	if fi.IsDir() {
+		err = syscall.Mount("tmpfs", path, "tmpfs", syscall.MS_RDONLY, "size=0")
+	} else {
+		err = syscall.Mount("/dev/null", path, "", syscall.MS_BIND, "")
+	}
+	return errors.Wrapf(err, "mask %s", path)
+}
+
+// readonlyPath 把路径 bind mount 到自己身上之后重新挂载为只读，路径不存在时忽略
+func readonlyPath(path string) error {
+	if err := syscall.Mount(path, path, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return errors.Wrapf(err, "bind mount %s", path)
+	}
+	return remountReadonly(path)
+}
+
+// remountReadonly 将挂载点重新挂载为只读
+/*
+	user namespace 中 nosuid、nodev、noexec 等标志是被锁住的，remount 时必须带上原来的标志，
+	否则内核会返回 EPERM，所以先通过 statfs 读出挂载点当前的标志。
+*/
+func remountReadonly(path string) error {
+	var st unix.Statfs_t
+	if err := unix.Statfs(path, &st); err != nil {
+		return errors.Wrapf(err, "statfs %s", path)
+	}
+	locked := uintptr(st.Flags) & (unix.MS_NOSUID | unix.MS_NODEV | unix.MS_NOEXEC | unix.MS_NOATIME | unix.MS_NODIRATIME | unix.MS_RELATIME)
+	flags := syscall.MS_BIND | syscall.MS_REMOUNT | syscall.MS_RDONLY | locked
+	return errors.Wrapf(syscall.Mount("", path, "", flags, ""), "remount %s read-only", path)
+}
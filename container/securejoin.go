@@ -0,0 +1,65 @@
+package container
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// maxSymlinks 解析路径时最多跟随的符号链接数，和内核的 MAXSYMLINKS 一致
+const maxSymlinks = 40
+
+// secureJoin 把容器内的路径 unsafePath 解析为 rootfs 下的宿主机路径
+/*
+	rootfs 由镜像控制，其中的符号链接可能指向宿主机上的任意位置，init 在 pivot_root 之前以 root 身份
+	创建挂载点并挂载，直接 filepath.Join 之后再 open 或 mount 会跟随这些链接跳出 rootfs。
+	这里逐级解析符号链接，绝对路径的链接和 .. 都限制在 rootfs 之内，和 chroot 到 rootfs 之后看到的路径一致，
+	不存在的部分原样拼接。此时容器中只有 init 一个进程，不用担心解析之后路径被替换。
+*/
+func secureJoin(rootfs, unsafePath string) (string, error) {
+	resolved := "/"
+	remaining := unsafePath
+	links := 0
+	for remaining != "" {
+		part := remaining
+		remaining = ""
+		if i := strings.IndexByte(part, '/'); i != -1 {
+			part, remaining = part[:i], part[i+1:]
+		}
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			resolved = filepath.Dir(resolved)
+			continue
+		}
+		next := filepath.Join(resolved, part)
+		fi, err := os.Lstat(filepath.Join(rootfs, next))
+		if err != nil {
+			if os.IsNotExist(err) {
+				resolved = next
+				continue
+			}
+			return "", errors.Wrapf(err, "lstat %s", next)
+		}
+		if fi.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+		if links++; links > maxSymlinks {
+			return "", errors.Errorf("too many levels of symbolic links in %s", unsafePath)
+		}
+		target, err := os.Readlink(filepath.Join(rootfs, next))
+		if err != nil {
+			return "", errors.Wrapf(err, "readlink %s", next)
+		}
+		// 绝对路径的链接从 rootfs 的根开始解析，相对路径的链接从链接所在的目录开始
+		if filepath.IsAbs(target) {
+			resolved = "/"
+		}
+		remaining = target + "/" + remaining
+	}
+	return filepath.Join(rootfs, resolved), nil
+}
@@ -0,0 +1,48 @@
+package container
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSecureJoin(t *testing.T) {
+	rootfs := t.TempDir()
+	for _, dir := range []string{"etc", "usr/lib"} {
+		if err := os.MkdirAll(filepath.Join(rootfs, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	links := map[string]string{
+		"etc/hostname":    "/etc/shadow",
+		"etc/resolv.conf": "../../../../run/resolv.conf",
+		"lib":             "usr/lib",
+		"loop":            "loop",
+	}
+	for link, target := range links {
+		if err := os.Symlink(target, filepath.Join(rootfs, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cases := []struct {
+		path, want string
+	}{
+		{"/etc/hostname", "/etc/shadow"},
+		{"/etc/resolv.conf", "/run/resolv.conf"},
+		{"/lib/x/y", "/usr/lib/x/y"},
+		{"/../../etc/hosts", "/etc/hosts"},
+		{"/new/dir", "/new/dir"},
+	}
+	for _, c := range cases {
+		got, err := secureJoin(rootfs, c.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := filepath.Join(rootfs, c.want); got != want {
+			t.Errorf("secureJoin(%s) = %s, want %s", c.path, got, want)
+		}
+	}
+	if _, err := secureJoin(rootfs, "/loop/x"); err == nil {
+		t.Error("secureJoin with a symlink loop expect error")
+	}
+}
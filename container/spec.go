@@ -0,0 +1,292 @@
+package container
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mydocker/constant"
+	"mydocker/oci"
+	"mydocker/seccomp"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// InitSpecVersion init 管道协议的版本号，父子进程版本不一致时 init 直接报错退出
+const InitSpecVersion = 1
+
+// InitSpec 父进程通过 init 管道发送给容器 init 进程的完整配置
+/*
+	之前管道里只有用空格拼接的命令，带空格或者为空的参数都会被破坏。
+	现在统一用 JSON 描述 init 需要的所有信息，init 管道是父进程与 init 之间唯一的配置通道。
+*/
+type InitSpec struct {
+	Version  int      `json:"version"`  // 协议版本，必须等于 InitSpecVersion
+	Args     []string `json:"args"`     // 用户命令及参数，Args[0] 为可执行文件
+	Env      []string `json:"env"`      // 用户进程的环境变量
+	Cwd      string   `json:"cwd"`      // 用户进程的工作目录
+	User     string   `json:"user"`     // 运行用户，格式为 user[:group]，可以是名字或者数字
+	Hostname string   `json:"hostname"` // 容器 hostname，为空则不设置
+	Rootfs   string   `json:"rootfs"`   // 容器 rootfs 在宿主机上的路径
+	Mounts   []Mount  `json:"mounts"`   // pivot_root 之前需要挂载到 rootfs 下的挂载点
+	Rlimits  []Rlimit `json:"rlimits"`  // 用户进程的资源限制
+	ExecFifo bool     `json:"execFifo"` // 为 true 时需要等待 start 命令打开 exec.fifo 才执行用户进程
+
+	Domainname       string   `json:"domainname,omitempty"`       // 容器 NIS domainname，为空则不设置
+	AdditionalGroups []string `json:"additionalGroups,omitempty"` // 附加组，可以是组名或者 gid
+
+	UserNS      bool   `json:"userns"`                // 容器运行在新的 user namespace 中
+	CgroupNS    bool   `json:"cgroupns,omitempty"`    // init 加入容器的 cgroup 之后创建新的 cgroup namespace
+	RootfsMount *Mount `json:"rootfsMount,omitempty"` // rootless 模式下由 init 自己挂载到 Rootfs 的 overlayfs
+
+	Seccomp      *seccomp.Profile `json:"seccomp,omitempty"`      // 执行用户进程之前安装的 seccomp profile，为空时不限制
+	Capabilities *Capabilities    `json:"capabilities,omitempty"` // 用户进程的 capability，为空时保持 init 的 capability 不变
+
+	NoNewPrivileges bool `json:"noNewPrivileges,omitempty"` // 设置 no_new_privs，setuid 程序和文件 capability 不能再提升权限
+
+	MaskedPaths    []string `json:"maskedPaths,omitempty"`    // 用 /dev/null 或者空的 tmpfs 遮住的路径
+	ReadonlyPaths  []string `json:"readonlyPaths,omitempty"`  // 重新挂载为只读的路径
+	ReadonlyRootfs bool     `json:"readonlyRootfs,omitempty"` // 所有挂载完成之后把 rootfs 重新挂载为只读
+
+	Devices []Device `json:"devices"`           // 私有 /dev 中的设备
+	ShmSize int64    `json:"shmSize,omitempty"` // /dev/shm 的大小，单位为字节，为 0 时使用 DefaultShmSize
+
+	Init bool `json:"init,omitempty"` // init 保持为 PID 1，负责转发信号和回收僵尸进程
+
+	Sysctl map[string]string `json:"sysctl,omitempty"` // 在容器的 namespace 中设置的内核参数
+
+	TimeOffsets *TimeOffsets `json:"timeOffsets,omitempty"` // 不为空时 init 创建新的 time namespace 并设置时钟偏移
+}
+
+// Mount 一个挂载点，Destination 为容器内路径，Options 与 mount 命令的 -o 参数一致
+type Mount struct {
+	Source      string   `json:"source"`
+	Destination string   `json:"destination"`
+	Type        string   `json:"type"`
+	Options     []string `json:"options"`
+}
+
+// Rlimit 一项资源限制，Type 为 RLIMIT_NOFILE 这样的名字
+type Rlimit struct {
+	Type string `json:"type"`
+	Hard uint64 `json:"hard"`
+	Soft uint64 `json:"soft"`
+}
+
+// NewInitSpec 根据用户命令和环境变量生成默认的 InitSpec
+func NewInitSpec(containerName string, cmdList, envSlice []string) *InitSpec {
+	return &InitSpec{
+		Version: InitSpecVersion,
+		Args:    cmdList,
+		Env:     envSlice,
+		Cwd:     "/",
+		Rootfs:  getMerged(containerName),
+		Devices: DefaultDevices,
+	}
+}
+
+// NewInitSpecFromOCI 将 OCI spec 中的 process、hostname 和 mounts 转换为 InitSpec
+/*
+	/proc 和 /dev 由 init 自己挂载，这里会跳过 spec 中的同名挂载点，
+	否则它们会被 init 的挂载覆盖掉。
+*/
+func NewInitSpecFromOCI(spec *oci.Spec, rootfs string) *InitSpec {
+	p := spec.Process
+	initSpec := &InitSpec{
+		Version:  InitSpecVersion,
+		Args:     p.Args,
+		Env:      p.Env,
+		Cwd:      p.Cwd,
+		User:     fmt.Sprintf("%d:%d", p.User.UID, p.User.GID),
+		Hostname: spec.Hostname,
+		Rootfs:   rootfs,
+
+		Domainname:      spec.Domainname,
+		ReadonlyRootfs:  spec.Root.Readonly,
+		NoNewPrivileges: p.NoNewPrivileges,
+		Devices:         DefaultDevices,
+	}
+	for _, gid := range p.User.AdditionalGids {
+		initSpec.AdditionalGroups = append(initSpec.AdditionalGroups, strconv.FormatUint(uint64(gid), 10))
+	}
+	if spec.Linux != nil {
+		initSpec.MaskedPaths = spec.Linux.MaskedPaths
+		initSpec.ReadonlyPaths = spec.Linux.ReadonlyPaths
+		initSpec.Sysctl = spec.Linux.Sysctl
+	}
+	for _, m := range spec.Mounts {
+		if isRuntimeMount(m.Destination) {
+			log.Infof("skip mount %s, it is managed by mydocker", m.Destination)
+			continue
+		}
+		// 和 runc 一样，type 为 cgroup 的挂载点表示按宿主机的方式挂载所有 cgroup hierarchy
+		if m.Type == "cgroup" {
+			readonly := false
+			for _, o := range m.Options {
+				readonly = readonly || o == "ro"
+			}
+			mounts, err := CgroupMounts(readonly)
+			if err != nil {
+				log.Warnf("skip mount %s: %v", m.Destination, err)
+				continue
+			}
+			initSpec.Mounts = append(initSpec.Mounts, mounts...)
+			if readonly {
+				initSpec.ReadonlyPaths = append(initSpec.ReadonlyPaths, cgroupRoot)
+			}
+			continue
+		}
+		initSpec.Mounts = append(initSpec.Mounts, Mount{
+			Source:      m.Source,
+			Destination: m.Destination,
+			Type:        m.Type,
+			Options:     m.Options,
+		})
+	}
+	for _, r := range p.Rlimits {
+		initSpec.Rlimits = append(initSpec.Rlimits, Rlimit{Type: r.Type, Hard: r.Hard, Soft: r.Soft})
+	}
+	if c := p.Capabilities; c != nil {
+		initSpec.Capabilities = &Capabilities{
+			Bounding:    c.Bounding,
+			Effective:   c.Effective,
+			Inheritable: c.Inheritable,
+			Permitted:   c.Permitted,
+			Ambient:     c.Ambient,
+		}
+	}
+	return initSpec
+}
+
+// isRuntimeMount 判断挂载点是否由 init 自己负责挂载
+func isRuntimeMount(dest string) bool {
+	dest = filepath.Clean("/" + dest)
+	return dest == "/proc" || dest == "/dev" || strings.HasPrefix(dest, "/dev/")
+}
+
+// WriteInitSpec 将 spec 编码后写入管道
+func WriteInitSpec(w io.Writer, spec *InitSpec) error {
+	return errors.Wrap(json.NewEncoder(w).Encode(spec), "encode init spec")
+}
+
+// ReadInitSpec 从管道中读取并校验 spec
+func ReadInitSpec(r io.Reader) (*InitSpec, error) {
+	spec := new(InitSpec)
+	if err := json.NewDecoder(r).Decode(spec); err != nil {
+		return nil, errors.Wrap(err, "decode init spec")
+	}
+	if spec.Version != InitSpecVersion {
+		return nil, fmt.Errorf("init spec version %d not supported, want %d", spec.Version, InitSpecVersion)
+	}
+	if len(spec.Args) == 0 {
+		return nil, errors.New("init spec has no args")
+	}
+	return spec, nil
+}
+
+// mountFlags 将 mount 选项转换为 flag，无法识别的选项作为 data 传给文件系统
+var mountFlags = map[string]struct {
+	clear bool
+	flag  uintptr
+}{
+	"bind":        {false, syscall.MS_BIND},
+	"rbind":       {false, syscall.MS_BIND | syscall.MS_REC},
+	"ro":          {false, syscall.MS_RDONLY},
+	"rw":          {true, syscall.MS_RDONLY},
+	"nosuid":      {false, syscall.MS_NOSUID},
+	"suid":        {true, syscall.MS_NOSUID},
+	"nodev":       {false, syscall.MS_NODEV},
+	"dev":         {true, syscall.MS_NODEV},
+	"noexec":      {false, syscall.MS_NOEXEC},
+	"exec":        {true, syscall.MS_NOEXEC},
+	"noatime":     {false, syscall.MS_NOATIME},
+	"nodiratime":  {false, syscall.MS_NODIRATIME},
+	"relatime":    {false, syscall.MS_RELATIME},
+	"strictatime": {false, syscall.MS_STRICTATIME},
+	"private":     {false, syscall.MS_PRIVATE},
+	"rprivate":    {false, syscall.MS_PRIVATE | syscall.MS_REC},
+	"slave":       {false, syscall.MS_SLAVE},
+	"rslave":      {false, syscall.MS_SLAVE | syscall.MS_REC},
+	"shared":      {false, syscall.MS_SHARED},
+	"rshared":     {false, syscall.MS_SHARED | syscall.MS_REC},
+}
+
+const propagationFlags = syscall.MS_PRIVATE | syscall.MS_SLAVE | syscall.MS_SHARED | syscall.MS_UNBINDABLE
+
+// parseMountOptions 解析挂载选项，返回挂载 flag、传播类型 flag 以及 data
+func parseMountOptions(options []string) (flags, propagation uintptr, data string) {
+	var dataOpts []string
+	for _, o := range options {
+		f, ok := mountFlags[o]
+		if !ok {
+			dataOpts = append(dataOpts, o)
+			continue
+		}
+		switch {
+		case f.clear:
+			flags &^= f.flag
+		case f.flag&propagationFlags != 0:
+			propagation |= f.flag
+		default:
+			flags |= f.flag
+		}
+	}
+	return flags, propagation, strings.Join(dataOpts, ",")
+}
+
+// mountTo 将 m 挂载到 rootfs 下对应的目录
+func mountTo(rootfs string, m Mount) error {
+	dest, err := secureJoin(rootfs, m.Destination)
+	if err != nil {
+		return err
+	}
+	flags, propagation, data := parseMountOptions(m.Options)
+	if err := createMountpoint(m.Source, dest, flags&syscall.MS_BIND != 0); err != nil {
+		return err
+	}
+	source := m.Source
+	if source == "" {
+		source = m.Type
+	}
+	if err := syscall.Mount(source, dest, m.Type, flags, data); err != nil {
+		// 没有自己的 network namespace 时不能挂载新的 sysfs，和 runc 一样改为 bind mount 宿主机的 /sys
+		if m.Type != "sysfs" || err != syscall.EPERM {
+			return errors.Wrapf(err, "mount %s to %s", source, dest)
+		}
+		return mountTo(rootfs, Mount{Source: "/sys", Destination: m.Destination, Options: append([]string{"rbind"}, m.Options...)})
+	}
+	// bind mount 时 ro、nosuid 等选项会被忽略，需要再 remount 一次才能生效
+	if flags&syscall.MS_BIND != 0 && flags&^(syscall.MS_BIND|syscall.MS_REC) != 0 {
+		if err := syscall.Mount("", dest, "", flags|syscall.MS_REMOUNT, data); err != nil {
+			return errors.Wrapf(err, "remount %s", dest)
+		}
+	}
+	if propagation != 0 {
+		if err := syscall.Mount("", dest, "", propagation, ""); err != nil {
+			return errors.Wrapf(err, "change propagation of %s", dest)
+		}
+	}
+	return nil
+}
+
+// createMountpoint 创建挂载点，bind mount 一个文件时挂载点也必须是文件
+func createMountpoint(source, dest string, bind bool) error {
+	if bind {
+		if fi, err := os.Stat(source); err == nil && !fi.IsDir() {
+			if err = os.MkdirAll(filepath.Dir(dest), constant.Perm0755); err != nil {
+				return errors.Wrapf(err, "mkdir %s", filepath.Dir(dest))
+			}
+			f, err := os.OpenFile(dest, os.O_CREATE, constant.Perm0644)
+			if err != nil {
+				return errors.Wrapf(err, "create %s", dest)
+			}
+			return f.Close()
+		}
+	}
+	return errors.Wrapf(os.MkdirAll(dest, constant.Perm0755), "mkdir %s", dest)
+}
@@ -0,0 +1,45 @@
+package container
+
+import (
+	"bytes"
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+func TestInitSpecRoundTrip(t *testing.T) {
+	args := []string{"sh", "-c", "echo a  b", ""}
+	var buf bytes.Buffer
+	if err := WriteInitSpec(&buf, NewInitSpec("test", args, []string{"A=1 2"})); err != nil {
+		t.Fatal(err)
+	}
+	spec, err := ReadInitSpec(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(spec.Args, args) {
+		t.Fatalf("args mangled: %q", spec.Args)
+	}
+	if spec.Env[0] != "A=1 2" {
+		t.Fatalf("env mangled: %q", spec.Env)
+	}
+}
+
+func TestReadInitSpecVersion(t *testing.T) {
+	if _, err := ReadInitSpec(bytes.NewBufferString(`{"version":0,"args":["sh"]}`)); err == nil {
+		t.Fatal("expect version mismatch error")
+	}
+}
+
+func TestParseMountOptions(t *testing.T) {
+	flags, propagation, data := parseMountOptions([]string{"rbind", "ro", "nosuid", "rprivate", "size=64m", "mode=755"})
+	if flags != syscall.MS_BIND|syscall.MS_REC|syscall.MS_RDONLY|syscall.MS_NOSUID {
+		t.Fatalf("unexpected flags %#x", flags)
+	}
+	if propagation != syscall.MS_PRIVATE|syscall.MS_REC {
+		t.Fatalf("unexpected propagation %#x", propagation)
+	}
+	if data != "size=64m,mode=755" {
+		t.Fatalf("unexpected data %s", data)
+	}
+}
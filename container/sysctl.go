@@ -0,0 +1,73 @@
+package container
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"mydocker/constant"
+
+	"github.com/pkg/errors"
+)
+
+// ipcSysctls 由 IPC namespace 隔离的 kernel 参数
+var ipcSysctls = map[string]bool{
+	"kernel.msgmax":          true,
+	"kernel.msgmnb":          true,
+	"kernel.msgmni":          true,
+	"kernel.sem":             true,
+	"kernel.shmall":          true,
+	"kernel.shmmax":          true,
+	"kernel.shmmni":          true,
+	"kernel.shm_rmid_forced": true,
+}
+
+// ParseSysctls 解析 --sysctl key=value，key 也可以写成 net/core/somaxconn 这样的路径形式
+func ParseSysctls(values []string) (map[string]string, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+	sysctls := make(map[string]string, len(values))
+	for _, v := range values {
+		key, value, ok := strings.Cut(v, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid sysctl %s, format should be key=value", v)
+		}
+		sysctls[strings.ReplaceAll(key, "/", ".")] = value
+	}
+	return sysctls, nil
+}
+
+// ValidateSysctl 只允许修改容器自己的 namespace 中的参数，其他参数会影响宿主机
+/*
+	net.* 只有容器有自己的 network namespace 时才允许，
+	kernel.shm*、kernel.msg*、kernel.sem 和 fs.mqueue.* 只有容器有自己的 IPC namespace 时才允许。
+*/
+func ValidateSysctl(key string, cloneflags uintptr) error {
+	switch {
+	case ipcSysctls[key] || strings.HasPrefix(key, "fs.mqueue."):
+		if cloneflags&syscall.CLONE_NEWIPC == 0 {
+			return fmt.Errorf("sysctl %s is not allowed when the container does not have its own IPC namespace", key)
+		}
+		return nil
+	case strings.HasPrefix(key, "net."):
+		if cloneflags&syscall.CLONE_NEWNET == 0 {
+			return fmt.Errorf("sysctl %s is not allowed when the container does not have its own network namespace", key)
+		}
+		return nil
+	}
+	return fmt.Errorf("sysctl %s is not namespaced and would change the host", key)
+}
+
+// setSysctls 写入 /proc/sys，必须在 /proc/sys 重新挂载为只读之前调用
+func setSysctls(sysctls map[string]string) error {
+	for key, value := range sysctls {
+		path := filepath.Join("/proc/sys", strings.ReplaceAll(key, ".", "/"))
+		if err := os.WriteFile(path, []byte(value), constant.Perm0644); err != nil {
+			return errors.Wrapf(err, "set sysctl %s", key)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package container
+
+import (
+	"syscall"
+	"testing"
+)
+
+func TestValidateSysctl(t *testing.T) {
+	allowed := []string{"net.core.somaxconn", "net.ipv4.ip_local_port_range", "kernel.msgmax", "kernel.shm_rmid_forced", "fs.mqueue.msg_max"}
+	for _, key := range allowed {
+		if err := ValidateSysctl(key, DefaultCloneflags); err != nil {
+			t.Errorf("ValidateSysctl(%s) error %v", key, err)
+		}
+	}
+	for _, key := range []string{"kernel.hostname", "kernel.pid_max", "vm.swappiness", "fs.file-max", "kernel.msgmaxx"} {
+		if err := ValidateSysctl(key, DefaultCloneflags); err == nil {
+			t.Errorf("ValidateSysctl(%s) expect error", key)
+		}
+	}
+	// 共享宿主机的 namespace 时对应的参数也不允许修改
+	if err := ValidateSysctl("net.core.somaxconn", DefaultCloneflags&^syscall.CLONE_NEWNET); err == nil {
+		t.Error("net sysctl should be rejected without network namespace")
+	}
+	if err := ValidateSysctl("kernel.shmmax", DefaultCloneflags&^syscall.CLONE_NEWIPC); err == nil {
+		t.Error("ipc sysctl should be rejected without ipc namespace")
+	}
+}
+
+func TestParseSysctls(t *testing.T) {
+	got, err := ParseSysctls([]string{"net/core/somaxconn=1024", "net.ipv4.ip_local_port_range=20000 30000"})
+	if err != nil || got["net.core.somaxconn"] != "1024" || got["net.ipv4.ip_local_port_range"] != "20000 30000" {
+		t.Errorf("ParseSysctls = %v, %v", got, err)
+	}
+	if _, err = ParseSysctls([]string{"net.core.somaxconn"}); err == nil {
+		t.Error("ParseSysctls without value expect error")
+	}
+}
@@ -0,0 +1,122 @@
+package container
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+
+	"mydocker/constant"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// TimeOffsets 容器 time namespace 中 CLOCK_MONOTONIC 和 CLOCK_BOOTTIME 相对宿主机的偏移
+type TimeOffsets struct {
+	Monotonic time.Duration `json:"monotonic,omitempty"`
+	Boottime  time.Duration `json:"boottime,omitempty"`
+}
+
+// ParseTimeOffsets 解析 --time-offset monotonic=<d>,boottime=<d>，d 为 720h 这样的时长，可以为负数
+func ParseTimeOffsets(value string) (*TimeOffsets, error) {
+	offsets := &TimeOffsets{}
+	for _, item := range strings.Split(value, ",") {
+		clock, d, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid time offset %s, format should be monotonic=<duration>,boottime=<duration>", item)
+		}
+		offset, err := time.ParseDuration(d)
+		if err != nil {
+			return nil, fmt.Errorf("invalid time offset %s: %v", item, err)
+		}
+		switch clock {
+		case "monotonic":
+			offsets.Monotonic = offset
+		case "boottime":
+			offsets.Boottime = offset
+		default:
+			return nil, fmt.Errorf("invalid time offset %s, clock should be monotonic or boottime", item)
+		}
+	}
+	return offsets, nil
+}
+
+// CheckTimeNamespace 检查内核是否支持 time namespace，Linux 5.6 开始才有
+/*
+	init 在 unshare 之后依靠 execve 进入新的 time namespace，但 Linux 5.18 之前 execve 不会切换，
+	偏移对用户进程不起作用。useInit 时用户进程由 init fork 出来，一出生就在新的 namespace 中，不受影响。
+*/
+func CheckTimeNamespace(useInit bool) error {
+	if _, err := os.Stat("/proc/self/ns/time"); err != nil {
+		return errors.New("time namespace is not supported by the kernel, --time-offset requires Linux 5.6 or later with CONFIG_TIME_NS")
+	}
+	if useInit {
+		return nil
+	}
+	switched, err := execSwitchesTimeNamespace()
+	if err != nil {
+		return err
+	}
+	if !switched {
+		return errors.New("the kernel does not move a process into its new time namespace at execve (Linux 5.18 or later does), use --init with --time-offset")
+	}
+	return nil
+}
+
+// execSwitchesTimeNamespace 启动一个 unshare time namespace 之后再 execve 的进程，看它是否进入了新的 time namespace
+/*
+	Go 在 fork 之后、execve 之前执行 Unshareflags 中的 unshare，和 init 的顺序一样。
+	探测进程是在 execve 之后一直等待信号的 pod-pause，非 root 用户在新的 user namespace 中才有权限 unshare。
+*/
+func execSwitchesTimeNamespace() (bool, error) {
+	cmd := exec.Command("/proc/self/exe", "pod-pause")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Unshareflags: unix.CLONE_NEWTIME}
+	if os.Geteuid() != 0 {
+		cmd.SysProcAttr.Cloneflags = syscall.CLONE_NEWUSER
+		cmd.SysProcAttr.UidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Geteuid(), Size: 1}}
+		cmd.SysProcAttr.GidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getegid(), Size: 1}}
+	}
+	if err := cmd.Start(); err != nil {
+		return false, errors.Wrap(err, "start time namespace probe")
+	}
+	defer func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	}()
+	own, err := os.Readlink("/proc/self/ns/time")
+	if err != nil {
+		return false, errors.Wrap(err, "read time namespace")
+	}
+	probe, err := os.Readlink(fmt.Sprintf("/proc/%d/ns/time", cmd.Process.Pid))
+	if err != nil {
+		return false, errors.Wrap(err, "read time namespace of the probe")
+	}
+	return probe != own, nil
+}
+
+// setUpTimeNamespace 创建新的 time namespace 并写入时钟偏移
+/*
+	unshare 之后 init 自己还在原来的 time namespace 中，新的 namespace 只对之后创建的进程生效，
+	执行 execve 时 init 也会进入其中。偏移只能在第一个进程进入之前通过 /proc/self/timens_offsets 设置，
+	所以必须在执行用户进程之前写入。
+*/
+func setUpTimeNamespace(offsets *TimeOffsets) error {
+	if err := unix.Unshare(unix.CLONE_NEWTIME); err != nil {
+		return errors.Wrap(err, "unshare time namespace")
+	}
+	content := formatTimeOffset("monotonic", offsets.Monotonic) + formatTimeOffset("boottime", offsets.Boottime)
+	return errors.Wrap(os.WriteFile("/proc/self/timens_offsets", []byte(content), constant.Perm0644), "write timens_offsets")
+}
+
+// formatTimeOffset 按 timens_offsets 的格式 <clock> <secs> <nanosecs> 输出，nanosecs 必须在 0 到 999999999 之间
+func formatTimeOffset(clock string, offset time.Duration) string {
+	secs, nsecs := int64(offset/time.Second), int64(offset%time.Second)
+	if nsecs < 0 {
+		secs--
+		nsecs += int64(time.Second)
+	}
+	return fmt.Sprintf("%s %d %d\n", clock, secs, nsecs)
+}
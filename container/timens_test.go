@@ -0,0 +1,37 @@
+package container
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTimeOffsets(t *testing.T) {
+	got, err := ParseTimeOffsets("monotonic=720h,boottime=-1.5s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Monotonic != 720*time.Hour || got.Boottime != -1500*time.Millisecond {
+		t.Errorf("ParseTimeOffsets = %+v", got)
+	}
+	for _, value := range []string{"", "monotonic", "monotonic=1d", "realtime=1h"} {
+		if _, err = ParseTimeOffsets(value); err == nil {
+			t.Errorf("ParseTimeOffsets(%q) expect error", value)
+		}
+	}
+}
+
+func TestFormatTimeOffset(t *testing.T) {
+	cases := []struct {
+		offset time.Duration
+		want   string
+	}{
+		{0, "boottime 0 0\n"},
+		{90*time.Minute + 5*time.Nanosecond, "boottime 5400 5\n"},
+		{-1500 * time.Millisecond, "boottime -2 500000000\n"},
+	}
+	for _, c := range cases {
+		if got := formatTimeOffset("boottime", c.offset); got != c.want {
+			t.Errorf("formatTimeOffset(%v) = %q, want %q", c.offset, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package container
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// ParseUlimit 解析 --ulimit 参数，格式为 name=soft[:hard]，比如 nofile=1024:4096
+/*
+	name 为去掉 RLIMIT_ 前缀的小写名字，只给出一个值时 soft 和 hard 相同，
+	unlimited 或者 -1 表示不限制。
+*/
+func ParseUlimit(value string) (Rlimit, error) {
+	name, limits, ok := strings.Cut(value, "=")
+	if !ok || name == "" || limits == "" {
+		return Rlimit{}, fmt.Errorf("invalid ulimit %s, format should be name=soft[:hard]", value)
+	}
+	typ := "RLIMIT_" + strings.ToUpper(name)
+	if _, ok = rlimitTypes[typ]; !ok {
+		return Rlimit{}, fmt.Errorf("unknown ulimit %s", name)
+	}
+	softStr, hardStr, hasHard := strings.Cut(limits, ":")
+	soft, err := parseRlimitValue(softStr)
+	if err != nil {
+		return Rlimit{}, fmt.Errorf("invalid ulimit %s: %v", value, err)
+	}
+	hard := soft
+	if hasHard {
+		if hard, err = parseRlimitValue(hardStr); err != nil {
+			return Rlimit{}, fmt.Errorf("invalid ulimit %s: %v", value, err)
+		}
+	}
+	if soft > hard {
+		return Rlimit{}, fmt.Errorf("invalid ulimit %s: soft limit %d is larger than hard limit %d", value, soft, hard)
+	}
+	return Rlimit{Type: typ, Soft: soft, Hard: hard}, nil
+}
+
+func parseRlimitValue(s string) (uint64, error) {
+	if s == "unlimited" || s == "-1" {
+		return unix.RLIM_INFINITY, nil
+	}
+	return strconv.ParseUint(s, 10, 64)
+}
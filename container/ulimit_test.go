@@ -0,0 +1,28 @@
+package container
+
+import (
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestParseUlimit(t *testing.T) {
+	cases := map[string]Rlimit{
+		"nofile=1024:4096":  {Type: "RLIMIT_NOFILE", Soft: 1024, Hard: 4096},
+		"nproc=100":         {Type: "RLIMIT_NPROC", Soft: 100, Hard: 100},
+		"core=0:unlimited":  {Type: "RLIMIT_CORE", Soft: 0, Hard: unix.RLIM_INFINITY},
+		"MEMLOCK=-1":        {Type: "RLIMIT_MEMLOCK", Soft: unix.RLIM_INFINITY, Hard: unix.RLIM_INFINITY},
+		"stack=8192:819200": {Type: "RLIMIT_STACK", Soft: 8192, Hard: 819200},
+	}
+	for value, want := range cases {
+		got, err := ParseUlimit(value)
+		if err != nil || got != want {
+			t.Errorf("ParseUlimit(%q) = %+v, %v, want %+v", value, got, err, want)
+		}
+	}
+	for _, value := range []string{"", "nofile", "nofile=", "foo=1", "nofile=a", "nofile=10:5", "nofile=1:2:3"} {
+		if _, err := ParseUlimit(value); err == nil {
+			t.Errorf("ParseUlimit(%q) expect error", value)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package container
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// sizeUnits 大小的单位，和 Docker 一样按 1024 进制计算
+var sizeUnits = map[string]int64{
+	"":   1,
+	"b":  1,
+	"k":  1 << 10,
+	"kb": 1 << 10,
+	"m":  1 << 20,
+	"mb": 1 << 20,
+	"g":  1 << 30,
+	"gb": 1 << 30,
+}
+
+// ParseSize 解析 64m、10mb、1g 这样的大小，返回字节数
+func ParseSize(s string) (int64, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	i := strings.IndexFunc(s, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+	if i < 0 {
+		i = len(s)
+	}
+	unit, ok := sizeUnits[s[i:]]
+	if !ok {
+		return 0, fmt.Errorf("invalid size %s, unknown unit %s", s, s[i:])
+	}
+	n, err := strconv.ParseFloat(s[:i], 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %s", s)
+	}
+	return int64(n * float64(unit)), nil
+}
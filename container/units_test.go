@@ -0,0 +1,32 @@
+package container
+
+import "testing"
+
+func TestParseSize(t *testing.T) {
+	cases := map[string]int64{
+		"1024":  1024,
+		"64m":   64 << 20,
+		"10MB":  10 << 20,
+		"1g":    1 << 30,
+		"1.5k":  1536,
+		"512b":  512,
+		"2 gb ": 0,
+	}
+	for s, want := range cases {
+		got, err := ParseSize(s)
+		if want == 0 {
+			if err == nil {
+				t.Errorf("ParseSize(%q) expect error", s)
+			}
+			continue
+		}
+		if err != nil || got != want {
+			t.Errorf("ParseSize(%q) = %d, %v, want %d", s, got, err, want)
+		}
+	}
+	for _, s := range []string{"", "m", "10x", "-1m"} {
+		if _, err := ParseSize(s); err == nil {
+			t.Errorf("ParseSize(%q) expect error", s)
+		}
+	}
+}
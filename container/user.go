@@ -0,0 +1,186 @@
+package container
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	passwdPath = "/etc/passwd"
+	groupPath  = "/etc/group"
+)
+
+// execUser 用户进程最终使用的 uid、gid、附加组和家目录
+type execUser struct {
+	Uid   int
+	Gid   int
+	Sgids []int
+	Home  string
+}
+
+// passwdEntry /etc/passwd 中的一行
+type passwdEntry struct {
+	name string
+	uid  int
+	gid  int
+	home string
+}
+
+// groupEntry /etc/group 中的一行
+type groupEntry struct {
+	name    string
+	gid     int
+	members []string
+}
+
+// resolveUser 在容器的 /etc/passwd 和 /etc/group 中解析 user[:group] 以及附加组
+/*
+	用户和组既可以是名字也可以是数字，名字必须在容器的文件中存在，数字则不要求。
+	和 Docker 一样，没有指定组时使用用户在 passwd 中的主组，并加上 group 文件中包含该用户的所有组。
+	文件不存在时当作空文件处理，这时只能使用数字。
+*/
+func resolveUser(user string, groupAdd []string, passwdFile, groupFile string) (*execUser, error) {
+	users, err := parsePasswd(passwdFile)
+	if err != nil {
+		return nil, err
+	}
+	groups, err := parseGroup(groupFile)
+	if err != nil {
+		return nil, err
+	}
+	userArg, groupArg, _ := strings.Cut(user, ":")
+	u := &execUser{Home: "/"}
+	name := ""
+	if userArg != "" {
+		p, found := findUser(users, userArg)
+		switch {
+		case found:
+			u.Uid, u.Gid, u.Home, name = p.uid, p.gid, p.home, p.name
+		case isNumeric(userArg):
+			u.Uid, _ = strconv.Atoi(userArg)
+		default:
+			return nil, fmt.Errorf("unable to find user %s: no matching entries in passwd file", userArg)
+		}
+	} else if p, found := findUser(users, "0"); found {
+		u.Home, name = p.home, p.name
+	}
+	if groupArg != "" {
+		if u.Gid, err = lookupGroup(groups, groupArg); err != nil {
+			return nil, err
+		}
+	} else if name != "" {
+		for _, g := range groups {
+			for _, m := range g.members {
+				if m == name && g.gid != u.Gid {
+					u.Sgids = append(u.Sgids, g.gid)
+				}
+			}
+		}
+	}
+	for _, g := range groupAdd {
+		gid, err := lookupGroup(groups, g)
+		if err != nil {
+			return nil, err
+		}
+		u.Sgids = append(u.Sgids, gid)
+	}
+	return u, nil
+}
+
+// findUser 先按名字查找，找不到时再按 uid 查找
+func findUser(users []passwdEntry, arg string) (passwdEntry, bool) {
+	for _, p := range users {
+		if p.name == arg {
+			return p, true
+		}
+	}
+	if uid, err := strconv.Atoi(arg); err == nil {
+		for _, p := range users {
+			if p.uid == uid {
+				return p, true
+			}
+		}
+	}
+	return passwdEntry{}, false
+}
+
+// lookupGroup 解析组名或者 gid
+func lookupGroup(groups []groupEntry, arg string) (int, error) {
+	for _, g := range groups {
+		if g.name == arg {
+			return g.gid, nil
+		}
+	}
+	if isNumeric(arg) {
+		return strconv.Atoi(arg)
+	}
+	return 0, fmt.Errorf("unable to find group %s: no matching entries in group file", arg)
+}
+
+func isNumeric(s string) bool {
+	_, err := strconv.ParseUint(s, 10, 32)
+	return err == nil
+}
+
+// parsePasswd 解析 passwd 文件，格式为 name:password:uid:gid:gecos:home:shell
+func parsePasswd(path string) ([]passwdEntry, error) {
+	var users []passwdEntry
+	err := parseColonFile(path, func(fields []string) {
+		if len(fields) < 7 {
+			return
+		}
+		uid, err1 := strconv.Atoi(fields[2])
+		gid, err2 := strconv.Atoi(fields[3])
+		if err1 != nil || err2 != nil {
+			return
+		}
+		users = append(users, passwdEntry{name: fields[0], uid: uid, gid: gid, home: fields[5]})
+	})
+	return users, err
+}
+
+// parseGroup 解析 group 文件，格式为 name:password:gid:member1,member2
+func parseGroup(path string) ([]groupEntry, error) {
+	var groups []groupEntry
+	err := parseColonFile(path, func(fields []string) {
+		if len(fields) < 4 {
+			return
+		}
+		gid, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return
+		}
+		g := groupEntry{name: fields[0], gid: gid}
+		if fields[3] != "" {
+			g.members = strings.Split(fields[3], ",")
+		}
+		groups = append(groups, g)
+	})
+	return groups, err
+}
+
+// parseColonFile 逐行解析以冒号分隔的文件，跳过空行和注释，文件不存在时不报错
+func parseColonFile(path string, fn func(fields []string)) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "open %s", path)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fn(strings.Split(line, ":"))
+	}
+	return errors.Wrapf(scanner.Err(), "read %s", path)
+}
@@ -0,0 +1,54 @@
+package container
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestResolveUser(t *testing.T) {
+	dir := t.TempDir()
+	passwd := filepath.Join(dir, "passwd")
+	group := filepath.Join(dir, "group")
+	if err := os.WriteFile(passwd, []byte("root:x:0:0:root:/root:/bin/sh\nalice:x:1000:1000::/home/alice:/bin/sh\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(group, []byte("root:x:0:\nalice:x:1000:\nstaff:x:50:alice,bob\naudio:x:29:\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		user     string
+		groupAdd []string
+		want     execUser
+	}{
+		{"", nil, execUser{Uid: 0, Gid: 0, Home: "/root"}},
+		{"alice", nil, execUser{Uid: 1000, Gid: 1000, Sgids: []int{50}, Home: "/home/alice"}},
+		{"1000", nil, execUser{Uid: 1000, Gid: 1000, Sgids: []int{50}, Home: "/home/alice"}},
+		{"alice:audio", []string{"staff", "77"}, execUser{Uid: 1000, Gid: 29, Sgids: []int{50, 77}, Home: "/home/alice"}},
+		{"4242:4242", nil, execUser{Uid: 4242, Gid: 4242, Home: "/"}},
+	}
+	for _, c := range cases {
+		got, err := resolveUser(c.user, c.groupAdd, passwd, group)
+		if err != nil {
+			t.Errorf("resolveUser(%q, %v) error %v", c.user, c.groupAdd, err)
+			continue
+		}
+		if !reflect.DeepEqual(*got, c.want) {
+			t.Errorf("resolveUser(%q, %v) = %+v, want %+v", c.user, c.groupAdd, *got, c.want)
+		}
+	}
+	for _, c := range []struct {
+		user     string
+		groupAdd []string
+	}{{"bob", nil}, {"alice:wheel", nil}, {"alice", []string{"wheel"}}} {
+		if _, err := resolveUser(c.user, c.groupAdd, passwd, group); err == nil {
+			t.Errorf("resolveUser(%q, %v) expect error", c.user, c.groupAdd)
+		}
+	}
+	// 镜像中没有 passwd 和 group 时只能使用数字
+	got, err := resolveUser("1000:1000", nil, filepath.Join(dir, "none"), filepath.Join(dir, "none"))
+	if err != nil || got.Uid != 1000 || got.Gid != 1000 {
+		t.Errorf("resolveUser without files = %+v, %v", got, err)
+	}
+}
@@ -0,0 +1,192 @@
+package container
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	subUIDFile = "/etc/subuid"
+	subGIDFile = "/etc/subgid"
+)
+
+// Rootless 是否以非 root 用户运行
+func Rootless() bool {
+	return os.Geteuid() != 0
+}
+
+// runtimeDir 容器信息的存放目录，rootless 模式下放到 $XDG_RUNTIME_DIR 中
+func runtimeDir() string {
+	if !Rootless() {
+		return "/var/run/mydocker/"
+	}
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "mydocker") + "/"
+	}
+	return fmt.Sprintf("/tmp/mydocker-%d/", os.Geteuid())
+}
+
+// dataDir 镜像和容器工作目录的存放目录，rootless 模式下放到 $HOME/.local/share/mydocker 中
+func dataDir() string {
+	if !Rootless() {
+		return "/root/"
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		return filepath.Join(home, ".local", "share", "mydocker") + "/"
+	}
+	return fmt.Sprintf("/tmp/mydocker-%d-data/", os.Geteuid())
+}
+
+// IDMappings 容器 user namespace 的 uid 和 gid 映射
+type IDMappings struct {
+	UIDs []syscall.SysProcIDMap
+	GIDs []syscall.SysProcIDMap
+}
+
+// NewIDMappings 根据 /etc/subuid 和 /etc/subgid 为当前用户生成 id 映射
+/*
+	root 用户运行时，容器内的 0~N 整段映射到 subuid 中分配给 root 的范围，容器 root 在宿主机上只是一个普通用户；
+	非 root 用户运行时，容器内的 0 映射为用户自己，1~N 映射到 subuid 中分配给该用户的范围。
+*/
+func NewIDMappings() (*IDMappings, error) {
+	u, err := user.Current()
+	if err != nil {
+		return nil, errors.Wrap(err, "get current user")
+	}
+	uidStart, uidCount, err := lookupSubID(subUIDFile, u.Username, u.Uid)
+	if err != nil {
+		return nil, err
+	}
+	gidStart, gidCount, err := lookupSubID(subGIDFile, u.Username, u.Uid)
+	if err != nil {
+		return nil, err
+	}
+	if !Rootless() {
+		return &IDMappings{
+			UIDs: []syscall.SysProcIDMap{{ContainerID: 0, HostID: uidStart, Size: uidCount}},
+			GIDs: []syscall.SysProcIDMap{{ContainerID: 0, HostID: gidStart, Size: gidCount}},
+		}, nil
+	}
+	return &IDMappings{
+		UIDs: []syscall.SysProcIDMap{
+			{ContainerID: 0, HostID: os.Geteuid(), Size: 1},
+			{ContainerID: 1, HostID: uidStart, Size: uidCount},
+		},
+		GIDs: []syscall.SysProcIDMap{
+			{ContainerID: 0, HostID: os.Getegid(), Size: 1},
+			{ContainerID: 1, HostID: gidStart, Size: gidCount},
+		},
+	}, nil
+}
+
+// lookupSubID 在 subuid/subgid 文件中查找用户的第一段范围，每行格式为 name:start:count，name 也可以是 uid
+func lookupSubID(file, name, uid string) (start, count int, err error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return 0, 0, errors.Wrapf(err, "open %s", file)
+	}
+	defer f.Close()
+	return parseSubID(bufio.NewScanner(f), file, name, uid)
+}
+
+func parseSubID(scanner *bufio.Scanner, file, name, uid string) (start, count int, err error) {
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, ":")
+		if len(fields) != 3 || (fields[0] != name && fields[0] != uid) {
+			continue
+		}
+		if start, err = strconv.Atoi(fields[1]); err != nil {
+			return 0, 0, fmt.Errorf("%s: invalid start %s", file, fields[1])
+		}
+		if count, err = strconv.Atoi(fields[2]); err != nil || count <= 0 {
+			return 0, 0, fmt.Errorf("%s: invalid count %s", file, fields[2])
+		}
+		return start, count, nil
+	}
+	if err = scanner.Err(); err != nil {
+		return 0, 0, errors.Wrapf(err, "read %s", file)
+	}
+	return 0, 0, fmt.Errorf("no range for user %s in %s", name, file)
+}
+
+// WriteIDMappings 用 newuidmap 和 newgidmap 为进程写入 id 映射
+/*
+	非 root 用户只能直接写入映射自己的一条记录，多段映射需要借助带 setuid 的 newuidmap 和 newgidmap。
+	root 用户则直接通过 SysProcAttr 的 UidMappings 和 GidMappings 写入，不需要调用这个函数。
+*/
+func WriteIDMappings(pid int, mappings *IDMappings) error {
+	if err := runIDMap("newuidmap", pid, mappings.UIDs); err != nil {
+		return err
+	}
+	return runIDMap("newgidmap", pid, mappings.GIDs)
+}
+
+func runIDMap(tool string, pid int, maps []syscall.SysProcIDMap) error {
+	args := []string{strconv.Itoa(pid)}
+	for _, m := range maps {
+		args = append(args, strconv.Itoa(m.ContainerID), strconv.Itoa(m.HostID), strconv.Itoa(m.Size))
+	}
+	if output, err := exec.Command(tool, args...).CombinedOutput(); err != nil {
+		return errors.Wrapf(err, "%s %s: %s", tool, strings.Join(args, " "), output)
+	}
+	return nil
+}
+
+// hostID 将容器内的 id 转换为宿主机上的 id，没有映射的 id 返回 -1
+func hostID(maps []syscall.SysProcIDMap, id int) int {
+	for _, m := range maps {
+		if id >= m.ContainerID && id < m.ContainerID+m.Size {
+			return m.HostID + id - m.ContainerID
+		}
+	}
+	return -1
+}
+
+// RootUID 容器 root 在宿主机上对应的 uid
+func (m *IDMappings) RootUID() int {
+	return hostID(m.UIDs, 0)
+}
+
+// RootGID 容器 root 在宿主机上对应的 gid
+func (m *IDMappings) RootGID() int {
+	return hostID(m.GIDs, 0)
+}
+
+// ShiftOwnership 按照 id 映射修改 dir 下所有文件的属主，使容器内的 root 能够正常使用镜像中的文件
+func ShiftOwnership(dir string, mappings *IDMappings) error {
+	return filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		st, ok := info.Sys().(*syscall.Stat_t)
+		if !ok {
+			return nil
+		}
+		uid, gid := hostID(mappings.UIDs, int(st.Uid)), hostID(mappings.GIDs, int(st.Gid))
+		if uid < 0 || gid < 0 {
+			return nil
+		}
+		if err = os.Lchown(p, uid, gid); err != nil {
+			return errors.Wrapf(err, "chown %s", p)
+		}
+		// chown 会清除 setuid 和 setgid 位，需要恢复原来的权限
+		if info.Mode()&os.ModeSymlink == 0 && info.Mode()&(os.ModeSetuid|os.ModeSetgid) != 0 {
+			return errors.Wrapf(unix.Chmod(p, st.Mode&07777), "chmod %s", p)
+		}
+		return nil
+	})
+}
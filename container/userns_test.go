@@ -0,0 +1,34 @@
+package container
+
+import (
+	"bufio"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestParseSubID(t *testing.T) {
+	content := "# comment\nalice:100000:65536\n1001:165536:65536\n"
+	start, count, err := parseSubID(bufio.NewScanner(strings.NewReader(content)), "subuid", "bob", "1001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if start != 165536 || count != 65536 {
+		t.Fatalf("got %d:%d, want 165536:65536", start, count)
+	}
+	if _, _, err = parseSubID(bufio.NewScanner(strings.NewReader(content)), "subuid", "carol", "1002"); err == nil {
+		t.Fatal("expect error for user without range")
+	}
+}
+
+func TestHostID(t *testing.T) {
+	maps := []syscall.SysProcIDMap{
+		{ContainerID: 0, HostID: 1000, Size: 1},
+		{ContainerID: 1, HostID: 100000, Size: 65536},
+	}
+	for id, want := range map[int]int{0: 1000, 1: 100000, 100: 100099, 65537: -1} {
+		if got := hostID(maps, id); got != want {
+			t.Errorf("hostID(%d) = %d, want %d", id, got, want)
+		}
+	}
+}
@@ -5,10 +5,12 @@ import (
 	"mydocker/constant"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
 )
 
 /*
@@ -16,8 +18,9 @@ import (
 2. 创建 upper 和 worker 层
 3. 创建 merged 目录并挂载 overlayFS
 4. 如果有指定 volume 则挂载 volume
+使用 user namespace 时 idMap 不为 nil，需要在挂载之前把镜像文件的属主修改为映射后的 id
 */
-func NewWorkSpace(volume, imageName, containerName string) {
+func NewWorkSpace(volume, imageName, containerName string, idMap *IDMappings) {
 	err := createLower(imageName, containerName)
 	if err != nil {
 		log.Errorf("createLower err: %v", err)
@@ -28,6 +31,12 @@ func NewWorkSpace(volume, imageName, containerName string) {
 		log.Errorf("createUpperWorker err: %v", err)
 		return
 	}
+	if idMap != nil {
+		if err = shiftWorkSpace(containerName, idMap); err != nil {
+			log.Errorf("shiftWorkSpace err: %v", err)
+			return
+		}
+	}
 	err = mountOverlayFS(containerName)
 	if err != nil {
 		log.Errorf("mountOverlayFS err: %v", err)
@@ -46,6 +55,89 @@ func NewWorkSpace(volume, imageName, containerName string) {
 			log.Infof("volume parameter input is not correct.")
 		}
 	}
+	if idMap != nil {
+		if err = mountUserNSRootfs(containerName); err != nil {
+			log.Errorf("mountUserNSRootfs err: %v", err)
+		}
+	}
+}
+
+// NewRootlessWorkSpace rootless 模式下只创建目录，没有权限在宿主机上挂载 overlayfs，
+// 需要由 init 在自己的 user namespace 中挂载 RootlessMounts 返回的挂载点
+func NewRootlessWorkSpace(imageName, containerName string) error {
+	if err := createLower(imageName, containerName); err != nil {
+		return errors.Wrap(err, "createLower")
+	}
+	if err := createUpperWorker(containerName); err != nil {
+		return errors.Wrap(err, "createUpperWorker")
+	}
+	return errors.Wrapf(os.MkdirAll(getMerged(containerName), constant.Perm0777), "mkdir dir %s", getMerged(containerName))
+}
+
+// RootlessMounts rootless 模式下 init 需要挂载的 rootfs 和 volume
+func RootlessMounts(volume, containerName string) (rootfs *Mount, volumes []Mount) {
+	rootfs = &Mount{
+		Source: "overlay",
+		Type:   "overlay",
+		// 非特权的 overlayfs 需要使用 user.* 扩展属性
+		Options: []string{getOverlayFSDirs(getLower(containerName), getUpper(containerName), getWorker(containerName)), "userxattr"},
+	}
+	volumePaths := volumePathExtract(volume)
+	if len(volumePaths) == 2 && volumePaths[0] != "" && volumePaths[1] != "" {
+		volumes = append(volumes, Mount{
+			Source:      volumePaths[0],
+			Destination: volumePaths[1],
+			Options:     []string{"rbind"},
+		})
+	}
+	return rootfs, volumes
+}
+
+// UserNSRootfs root 使用 user namespace 时容器 rootfs 的路径
+/*
+	容器 root 在宿主机上只是一个普通用户，没有权限进入 /root 下的工作目录，
+	所以把 merged 目录再 bind mount 到一个所有人都能进入的目录下，init 通过这个路径访问 rootfs。
+*/
+func UserNSRootfs(containerName string) string {
+	return fmt.Sprintf(userNSRootfsFormat, containerName)
+}
+
+// mountUserNSRootfs 将 merged 目录 bind mount 到 UserNSRootfs
+func mountUserNSRootfs(containerName string) error {
+	rootfs := UserNSRootfs(containerName)
+	if err := os.MkdirAll(filepath.Dir(rootfs), constant.Perm0711); err != nil {
+		return errors.Wrapf(err, "mkdir %s", filepath.Dir(rootfs))
+	}
+	if err := os.MkdirAll(rootfs, constant.Perm0755); err != nil {
+		return errors.Wrapf(err, "mkdir %s", rootfs)
+	}
+	return errors.Wrapf(unix.Mount(getMerged(containerName), rootfs, "", unix.MS_BIND|unix.MS_REC, ""),
+		"bind mount %s", rootfs)
+}
+
+// umountUserNSRootfs 卸载并删除 UserNSRootfs，没有使用 user namespace 的容器不存在这个目录
+func umountUserNSRootfs(containerName string) error {
+	rootfs := UserNSRootfs(containerName)
+	if _, err := os.Stat(rootfs); os.IsNotExist(err) {
+		return nil
+	}
+	if err := unix.Unmount(rootfs, unix.MNT_DETACH); err != nil && err != unix.EINVAL {
+		return errors.Wrapf(err, "umount %s", rootfs)
+	}
+	return errors.Wrapf(os.Remove(rootfs), "remove %s", rootfs)
+}
+
+// shiftWorkSpace 将 lower、upper 和 work 目录的属主修改为映射后的 id
+func shiftWorkSpace(containerName string, idMap *IDMappings) error {
+	if err := ShiftOwnership(getLower(containerName), idMap); err != nil {
+		return err
+	}
+	for _, dir := range []string{getUpper(containerName), getWorker(containerName)} {
+		if err := os.Chown(dir, idMap.RootUID(), idMap.RootGID()); err != nil {
+			return errors.Wrapf(err, "chown %s", dir)
+		}
+	}
+	return nil
 }
 
 // 容器退出时删除文件系统
@@ -56,8 +148,11 @@ func NewWorkSpace(volume, imageName, containerName string) {
 */
 func DeleteWorkSpace(volume, containerName string) error {
 	log.Infof("volume: %s, containerName: %s", volume, containerName)
+	if err := umountUserNSRootfs(containerName); err != nil {
+		return err
+	}
 	// 先判断是否有 volume 挂载，如果有则要先 umount volume
-	if volume != "" {
+	if volume != "" && !Rootless() {
 		volumePaths := volumePathExtract(volume)
 		l := len(volumePaths)
 		if l == 2 && volumePaths[0] != "" && volumePaths[1] != "" {
@@ -67,13 +162,22 @@ func DeleteWorkSpace(volume, containerName string) error {
 			}
 		}
 	}
+	// rootless 模式下 overlayfs 是在容器的 mount namespace 中挂载的，宿主机上不需要 umount
+	// overlayfs 创建的 work/work 目录权限为 000，需要先修改权限才能删除
+	if Rootless() {
+		_ = os.Chmod(filepath.Join(getWorker(containerName), "work"), constant.Perm0755)
+	}
 	// 移除相关目录
 	err := removeDirs(containerName)
 	if err != nil {
 		return errors.Wrap(err, "removeDirs")
 	}
 	// umount 整个容器的挂载点
-	err = umountOverlayFS(containerName)
+	if Rootless() {
+		err = os.RemoveAll(getMerged(containerName))
+	} else {
+		err = umountOverlayFS(containerName)
+	}
 	if err != nil {
 		return errors.Wrap(err, "umountOverlayFS")
 	}
@@ -92,7 +196,7 @@ func createLower(imageName, containerName string) error {
 	lower := getLower(containerName)
 
 	// 不存在则创建目录并将镜像解压到对应目录
-	if err := os.MkdirAll(lower, constant.Perm0622); err != nil {
+	if err := os.MkdirAll(lower, constant.Perm0755); err != nil {
 		return errors.Wrapf(err, "mkdir %s", lower)
 	}
 	if _, err := exec.Command("tar", "-xvf", imagePath, "-C", lower).CombinedOutput(); err != nil {
@@ -220,6 +324,10 @@ func getImage(imageName string) string {
 	return RootPath + imageName + ".tar"
 }
 
+func getImageConfig(imageName string) string {
+	return RootPath + imageName + ".json"
+}
+
 func getLower(containerName string) string {
 	return fmt.Sprintf(lowerDirFormat, containerName)
 }
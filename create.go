@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"mydocker/constant"
+	"mydocker/container"
+	"mydocker/oci"
+
+	"github.com/creack/pty"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// createOCIContainer 从 bundle 创建容器，init 完成初始化后阻塞在 exec.fifo 上，等待 start 命令
+/*
+	terminal 为 true 时创建一个伪终端作为容器的标准输入输出，并把 master 端通过 console socket 发给调用方；
+	否则容器直接继承 create 命令的标准输入输出，由上层的容器管理程序负责处理。
+*/
+func createOCIContainer(id, bundle, pidFile, consoleSocket string) error {
+	if _, err := getContainerInfoByName(id); err == nil {
+		return fmt.Errorf("container %s already exists", id)
+	}
+	opts := &RunOptions{
+		ContainerName: id,
+		WaitStart:     true,
+	}
+	if err := loadBundle(bundle, opts); err != nil {
+		return err
+	}
+	if opts.TTY && consoleSocket == "" {
+		return errors.New("--console-socket is required when process.terminal is true")
+	}
+	if !opts.TTY && consoleSocket != "" {
+		return errors.New("--console-socket is only allowed when process.terminal is true")
+	}
+	_, parent, err := createContainer(opts, func(cmd *exec.Cmd) error {
+		if opts.TTY {
+			return startWithConsole(cmd, consoleSocket)
+		}
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		return cmd.Start()
+	})
+	if err != nil {
+		return err
+	}
+	if pidFile != "" {
+		return writePidFile(pidFile, parent.Process.Pid)
+	}
+	return nil
+}
+
+// startWithConsole 以伪终端的 slave 端作为容器的控制终端启动容器进程
+func startWithConsole(cmd *exec.Cmd, socketPath string) error {
+	ptmx, tty, err := pty.Open()
+	if err != nil {
+		return errors.Wrap(err, "open pty")
+	}
+	defer ptmx.Close()
+	defer tty.Close()
+	if err = sendConsole(socketPath, ptmx); err != nil {
+		return err
+	}
+	cmd.Stdin = tty
+	cmd.Stdout = tty
+	cmd.Stderr = tty
+	cmd.SysProcAttr.Setctty = true
+	return cmd.Start()
+}
+
+// sendConsole 通过 unix socket 的 SCM_RIGHTS 把伪终端 master 端的 fd 发给 console socket 的监听方
+func sendConsole(socketPath string, console *os.File) error {
+	conn, err := net.Dial("unix", socketPath)
+	if err != nil {
+		return errors.Wrapf(err, "dial console socket %s", socketPath)
+	}
+	defer conn.Close()
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return fmt.Errorf("console socket %s is not a unix socket", socketPath)
+	}
+	oob := unix.UnixRights(int(console.Fd()))
+	_, _, err = unixConn.WriteMsgUnix([]byte(console.Name()), oob, nil)
+	return errors.Wrap(err, "send console fd")
+}
+
+// writePidFile 先写临时文件再 rename，避免调用方读到写了一半的 pid
+func writePidFile(pidFile string, pid int) error {
+	tmpFile := pidFile + ".tmp"
+	if err := os.WriteFile(tmpFile, []byte(strconv.Itoa(pid)), constant.Perm0644); err != nil {
+		return errors.Wrapf(err, "write pid file %s", tmpFile)
+	}
+	return os.Rename(tmpFile, pidFile)
+}
+
+// startOCIContainer 打开 exec.fifo，让阻塞在上面的 init 继续执行用户进程
+func startOCIContainer(id string) error {
+	containerInfo, err := getContainerInfoByName(id)
+	if err != nil {
+		return err
+	}
+	if status := containerStatus(containerInfo); status != oci.StateCreated {
+		return fmt.Errorf("cannot start a container in %s state", status)
+	}
+	fifoPath := fmt.Sprintf(container.InfoLocFormat, id) + container.ExecFifoName
+	pid, _ := strconv.Atoi(containerInfo.Pid)
+	if err = readExecFifo(fifoPath, pid); err != nil {
+		return err
+	}
+	if err = os.Remove(fifoPath); err != nil {
+		log.Warnf("Remove %s error %v", fifoPath, err)
+	}
+	containerInfo.Status = container.RUNNING
+	if err = updateContainerInfo(containerInfo); err != nil {
+		return err
+	}
+	if hooks := containerInfo.Hooks; hooks != nil {
+		if err = oci.RunHooks(hooks.Poststart, containerState(containerInfo, oci.StateRunning)); err != nil {
+			log.Warnf("Run poststart hooks error %v", err)
+		}
+	}
+	return nil
+}
+
+const execFifoPollInterval = 100 * time.Millisecond
+
+// readExecFifo 读取 exec.fifo，如果 init 在打开 fifo 之前就退出了，open 会一直阻塞，所以同时检查 init 是否还活着
+func readExecFifo(fifoPath string, pid int) error {
+	result := make(chan error, 1)
+	go func() {
+		fifo, err := os.OpenFile(fifoPath, os.O_RDONLY, 0)
+		if err != nil {
+			result <- errors.Wrapf(err, "open %s", fifoPath)
+			return
+		}
+		defer fifo.Close()
+		content, err := io.ReadAll(fifo)
+		if err == nil && len(content) == 0 {
+			err = errors.New("container init exited before start")
+		}
+		result <- err
+	}()
+	ticker := time.NewTicker(execFifoPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-result:
+			return err
+		case <-ticker.C:
+			if !processAlive(pid) {
+				return errors.New("container init exited before start")
+			}
+		}
+	}
+}
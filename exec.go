@@ -1,68 +1,168 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
+	"mydocker/cgroups"
+	"mydocker/cgroups/subsystems"
 	"mydocker/container"
 	"os"
 	"os/exec"
+	"strconv"
 	"strings"
 
 	// 需要导入 nsenter 包，以触发 C 代码
 	_ "mydocker/nsenter"
 
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 )
 
 // 控制是否执行 C 代码里面的 setns
-const (
-	EnvExecPid = "mydocker_pid"
-	EnvExecCmd = "mydocker_cmd"
-)
+const EnvExecPid = "mydocker_pid"
+
+// ExecOptions exec 命令的参数
+type ExecOptions struct {
+	CapAdd     []string
+	CapDrop    []string
+	Privileged bool     // 拥有全部 capability 并且不启用 seccomp
+	User       string   // 为空时使用容器的用户
+	GroupAdd   []string // 为空时使用容器的附加组
+	Workdir    string   // 为空时使用容器的工作目录
+}
 
-func ExecContainer(containerName string, cmdList []string) {
-	// 获取容器的 Pid
-	pid, err := getContainerPidByName(containerName)
+func ExecContainer(containerName string, cmdList []string, opts *ExecOptions) error {
+	containerInfo, err := getContainerInfoByName(containerName)
 	if err != nil {
-		log.Errorf("Exec container getContainerPidByName %s error: %v", containerName, err)
-		return
+		return errors.Wrapf(err, "get container %s info", containerName)
+	}
+	pid := containerInfo.Pid
+
+	// 把指定 PID 进程的环境变量传递给新启动的进程，实现通过 exec 命令也能查询到容器的环境变量
+	// 宿主机的环境变量不会传进容器
+	spec := &container.InitSpec{
+		Version:      container.InitSpecVersion,
+		Args:         cmdList,
+		Env:          getEnvsByPid(pid),
+		Cwd:          containerInfo.Workdir,
+		User:         containerInfo.User,
+		Seccomp:      containerInfo.Seccomp,
+		Capabilities: containerInfo.Capabilities,
+		Rlimits:      containerInfo.Rlimits,
+
+		AdditionalGroups: containerInfo.GroupAdd,
+		NoNewPrivileges:  containerInfo.NoNewPrivileges,
+	}
+	if spec.Cwd == "" {
+		spec.Cwd = "/"
+	}
+	if opts.User != "" {
+		spec.User = opts.User
+	}
+	if len(opts.GroupAdd) > 0 {
+		spec.AdditionalGroups = opts.GroupAdd
+	}
+	if opts.Workdir != "" {
+		spec.Cwd = opts.Workdir
+	}
+	if err = execCapabilities(spec, opts); err != nil {
+		return err
+	}
+	// 容器本身没有 CAP_MKNOD 时，cgroup v2 上 exec 也不能得到它，原因见 dropMknod
+	if cgroups.IsCgroup2UnifiedMode() && !hasMknod(containerInfo.Capabilities) {
+		spec.Capabilities = dropMknod(spec.Capabilities)
+	}
+	if err = container.CheckSeccomp(spec); err != nil {
+		return err
 	}
 
+	readPipe, writePipe, err := os.Pipe()
+	if err != nil {
+		return errors.Wrap(err, "create pipe")
+	}
+	defer readPipe.Close()
 	// /proc/self/exe exec 重新启动了一个进程，所以 C 代码会重新调用
-	// 新的进程启动时，它将继承父进程的环境变量和其他上下文信息
-	// 这个时候已经设置好了环境变量，C 代码就能正确运行
+	// C 代码通过环境变量找到容器进程，进入它的 namespace 之后 fork 出的子进程从 readPipe 读取 InitSpec
 	cmd := exec.Command("/proc/self/exe", "exec")
 	cmd.Stdin = os.Stdin
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = []*os.File{readPipe}
+	cmd.Env = []string{EnvExecPid + "=" + pid}
 
-	cmdStr := strings.Join(cmdList, " ")
-	log.Infof("container pid: %s command: %s", pid, cmdStr)
-	_ = os.Setenv(EnvExecPid, pid)
-	_ = os.Setenv(EnvExecCmd, cmdStr)
-
-	// 把指定 PID 进程的环境变量传递给新启动的进程，实现通过 exec 命令也能查询到容器的环境变量
-	containerEnvs := getEnvsByPid(pid)
-	cmd.Env = append(os.Environ(), containerEnvs...)
+	log.Infof("container pid: %s command: %s", pid, strings.Join(cmdList, " "))
+	if err = cmd.Start(); err != nil {
+		writePipe.Close()
+		return errors.Wrap(err, "start exec process")
+	}
+	if containerInfo.CgroupPath != "" {
+		joinCgroup(containerInfo.CgroupPath, cmd.Process.Pid)
+	}
+	if err = sendInitCommand(spec, writePipe); err != nil {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		return err
+	}
+	return cmd.Wait()
+}
 
-	if err := cmd.Run(); err != nil {
-		log.Errorf("Exec container %s error: %v", containerName, err)
+// joinCgroup 把 exec 启动的进程加入容器的 cgroup，受容器的资源限制
+/*
+	nsenter 的 C 代码进入 namespace 之后 fork 出真正执行命令的子进程。父进程加入之后 fork 的子进程会继承 cgroup，
+	在这之前已经 fork 出来的子进程还在等待 InitSpec，不会再创建进程，从 children 中找到它再加入一次就不会遗漏。
+*/
+func joinCgroup(cgroupPath string, pid int) {
+	cgroupManager := cgroups.NewCgroupManager(cgroupPath)
+	cfg := &subsystems.ResourceConfig{}
+	if err := cgroupManager.Apply(pid, cfg); err != nil {
+		log.Warnf("Add exec process to cgroup error %v", err)
+	}
+	children, err := os.ReadFile(fmt.Sprintf("/proc/%d/task/%d/children", pid, pid))
+	if err != nil {
+		log.Warnf("Read children of exec process error %v", err)
+		return
+	}
+	for _, child := range strings.Fields(string(children)) {
+		if childPid, err := strconv.Atoi(child); err == nil {
+			if err = cgroupManager.Apply(childPid, cfg); err != nil {
+				log.Warnf("Add exec process to cgroup error %v", err)
+			}
+		}
 	}
 }
 
-func getContainerPidByName(containerName string) (string, error) {
-	dirPath := fmt.Sprintf(container.InfoLocFormat, containerName)
-	configFilePath := dirPath + container.ConfigName
-	// 读取内容并解析
-	content, err := os.ReadFile(configFilePath)
+// execCapabilities 在容器 capability 的基础上应用 exec 的 --cap-add、--cap-drop 和 --privileged
+func execCapabilities(spec *container.InitSpec, opts *ExecOptions) error {
+	if opts.Privileged {
+		spec.Capabilities = container.NewCapabilities(container.AllCapabilities())
+		spec.Seccomp = nil
+		return nil
+	}
+	if len(opts.CapAdd) == 0 && len(opts.CapDrop) == 0 {
+		return nil
+	}
+	base := container.DefaultCapabilities
+	if spec.Capabilities != nil {
+		base = spec.Capabilities.Effective
+	}
+	caps, err := container.MergeCapabilities(base, opts.CapAdd, opts.CapDrop)
 	if err != nil {
-		return "", err
+		return err
+	}
+	spec.Capabilities = container.NewCapabilities(caps)
+	return nil
+}
+
+// hasMknod 判断容器的 bounding set 中是否有 CAP_MKNOD，caps 为空时容器保持了 init 的所有 capability
+func hasMknod(caps *container.Capabilities) bool {
+	if caps == nil {
+		return true
 	}
-	var containerInfo container.Info
-	if err = json.Unmarshal(content, &containerInfo); err != nil {
-		return "", err
+	for _, v := range caps.Bounding {
+		if v == "CAP_MKNOD" {
+			return true
+		}
 	}
-	return containerInfo.Pid, nil
+	return false
 }
 
 func getEnvsByPid(pid string) []string {
@@ -73,6 +173,6 @@ func getEnvsByPid(pid string) []string {
 		return nil
 	}
 	// env split by \u0000
-	envs := strings.Split(string(content), "\u0000")
+	envs := strings.Split(strings.TrimSuffix(string(content), "\u0000"), "\u0000")
 	return envs
 }
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// inspectContainer 以 JSON 格式打印容器的详细信息
+func inspectContainer(containerName string) error {
+	containerInfo, err := getContainerInfoByName(containerName)
+	if err != nil {
+		return err
+	}
+	content, err := json.MarshalIndent(containerInfo, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "marshal container info")
+	}
+	_, err = fmt.Println(string(content))
+	return err
+}
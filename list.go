@@ -19,7 +19,7 @@ func ListContainers() {
 	}
 	containers := make([]*container.Info, 0, len(files))
 	for _, file := range files {
-		if file.Name() == "network" {
+		if file.Name() == "network" || file.Name() == podDirName {
 			continue
 		}
 		fileInfo, _ := file.Info()
@@ -10,7 +10,7 @@ import (
 )
 
 func logContainer(containerName string) {
-	logFileLoc := fmt.Sprintf(container.InfoLocFormat, containerName)
+	logFileLoc := fmt.Sprintf(container.InfoLocFormat, containerName) + container.Logfile
 	file, err := os.Open(logFileLoc)
 	if err != nil {
 		log.Errorf("Log container open file %s error: %v", logFileLoc, err)
@@ -1,7 +1,11 @@
 package main
 
 import (
+	"fmt"
 	"os"
+	"syscall"
+
+	"mydocker/constant"
 
 	log "github.com/sirupsen/logrus"
 
@@ -20,18 +24,56 @@ func main() {
 		runCommand,
 		commitCommand,
 		listCommand,
+		inspectCommand,
 		logCommand,
 		execCommand,
 		stopCommand,
+		updateCommand,
+		statsCommand,
 		removeCommand,
 		networkCommand,
+		podCommand,
+		podPauseCommand,
+		specCommand,
+		createCommand,
+		startCommand,
+		stateCommand,
+		killCommand,
+		deleteCommand,
+	}
+
+	app.Flags = []cli.Flag{
+		cli.StringFlag{
+			Name:  "log",
+			Usage: "log file path, default is stdout",
+		},
+		cli.StringFlag{
+			Name:  "log-format",
+			Value: "json",
+			Usage: "log format, json or text",
+		},
 	}
 
 	app.Before = func(context *cli.Context) error {
 		// Log as JSON instead of the default ASCII formatter.
-		log.SetFormatter(&log.JSONFormatter{})
+		switch context.GlobalString("log-format") {
+		case "json":
+			log.SetFormatter(&log.JSONFormatter{})
+		case "text":
+			log.SetFormatter(&log.TextFormatter{})
+		default:
+			return fmt.Errorf("unknown log format %s", context.GlobalString("log-format"))
+		}
 
 		log.SetOutput(os.Stdout)
+		// 作为其他程序的 runtime 使用时，stdout 可能是容器的输出，日志需要写到单独的文件中
+		if logPath := context.GlobalString("log"); logPath != "" {
+			f, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND|syscall.O_CLOEXEC, constant.Perm0644)
+			if err != nil {
+				return err
+			}
+			log.SetOutput(f)
+		}
 		return nil
 	}
 
@@ -3,10 +3,13 @@ package main
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 
 	"mydocker/cgroups/subsystems"
 	"mydocker/container"
 	"mydocker/network"
+	"mydocker/oci"
+	"mydocker/seccomp"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
@@ -16,10 +19,16 @@ var runCommand = cli.Command{
 	Name: "run",
 	Usage: `Create a container with namespace and cgroups limit
 			mydocker run -it [image] [command]`,
+	// 和 docker 一样可以把 -i -t 合并写成 -it
+	UseShortOptionHandling: true,
 	Flags: []cli.Flag{
 		cli.BoolFlag{
-			Name:  "it", // 简单起见，这里把 -i 和 -t 参数合并成一个
-			Usage: "enable tty",
+			Name:  "i",
+			Usage: "keep stdin open and pass it to the container",
+		},
+		cli.BoolFlag{
+			Name:  "t",
+			Usage: "allocate a pseudo-tty",
 		},
 		cli.BoolFlag{
 			Name:  "d",
@@ -45,22 +54,167 @@ var runCommand = cli.Command{
 			Name:  "mem",
 			Usage: "set memory limit",
 		},
+		cli.Int64Flag{
+			Name:  "pids-limit",
+			Usage: "limit the number of processes in the container, -1 for unlimited",
+		},
+		cli.IntFlag{
+			Name:  "blkio-weight",
+			Usage: "block IO weight, between 10 and 1000",
+		},
+		cli.StringSliceFlag{
+			Name:  "device-read-bps",
+			Usage: "limit read rate from a device, e.g. /dev/sda:10mb",
+		},
+		cli.StringSliceFlag{
+			Name:  "device-write-bps",
+			Usage: "limit write rate to a device, e.g. /dev/sda:10mb",
+		},
+		cli.StringSliceFlag{
+			Name:  "device-read-iops",
+			Usage: "limit read IO per second from a device, e.g. /dev/sda:1000",
+		},
+		cli.StringSliceFlag{
+			Name:  "device-write-iops",
+			Usage: "limit write IO per second to a device, e.g. /dev/sda:1000",
+		},
+		cli.StringFlag{
+			Name:  "cgroup-parent",
+			Usage: "parent cgroup of the container, the container's cgroup is <parent>/<id>, default is mydocker",
+		},
 		cli.StringFlag{
 			Name:  "v",
 			Usage: "volume",
 		},
 		cli.StringSliceFlag{
 			Name:  "e",
-			Usage: "set environment",
+			Usage: "set environment, use -e VAR to pass through VAR from host",
+		},
+		cli.StringSliceFlag{
+			Name:  "env-file",
+			Usage: "read environment from file",
 		},
 		cli.StringFlag{
 			Name:  "net",
-			Usage: "container network",
+			Usage: "container network, host to use the host's network or container:<name> to join another container's network",
+		},
+		cli.StringFlag{
+			Name:  "pod",
+			Usage: "run the container in a pod, sharing the pod's network, IPC and UTS namespaces",
+		},
+		cli.StringFlag{
+			Name:  "ipc",
+			Usage: "IPC namespace to use, private, host or container:<name>",
+		},
+		cli.StringFlag{
+			Name:  "pid",
+			Usage: "PID namespace to use, private, host or container:<name>",
+		},
+		cli.StringFlag{
+			Name:  "uts",
+			Usage: "UTS namespace to use, private, host or container:<name>",
+		},
+		cli.StringFlag{
+			Name:  "time-offset",
+			Usage: "run in a new time namespace with clock offsets, e.g. monotonic=720h,boottime=720h",
 		},
 		cli.StringSliceFlag{
 			Name:  "p",
 			Usage: "port mapping",
 		},
+		cli.StringFlag{
+			Name:  "bundle",
+			Usage: "run an OCI bundle, image and command are read from its config.json",
+		},
+		cli.StringSliceFlag{
+			Name:  "hook",
+			Usage: "run an executable at a lifecycle stage, format is <stage>=<path>, stage is prestart, createRuntime, poststart or poststop",
+		},
+		cli.IntFlag{
+			Name:  "hook-timeout",
+			Value: 30,
+			Usage: "timeout in seconds of each --hook",
+		},
+		cli.StringSliceFlag{
+			Name:  "security-opt",
+			Usage: "security options, seccomp=unconfined, seccomp=<profile.json> or no-new-privileges",
+		},
+		cli.StringSliceFlag{
+			Name:  "ulimit",
+			Usage: "set resource limits, format is name=soft[:hard], e.g. nofile=1024:4096",
+		},
+		cli.StringSliceFlag{
+			Name:  "sysctl",
+			Usage: "set namespaced kernel parameters, e.g. net.core.somaxconn=1024",
+		},
+		cli.StringFlag{
+			Name:  "hostname",
+			Usage: "container host name, default is the container id",
+		},
+		cli.StringFlag{
+			Name:  "domainname",
+			Usage: "container NIS domain name",
+		},
+		cli.StringSliceFlag{
+			Name:  "dns",
+			Usage: "set custom DNS servers",
+		},
+		cli.StringSliceFlag{
+			Name:  "dns-search",
+			Usage: "set custom DNS search domains",
+		},
+		cli.StringSliceFlag{
+			Name:  "dns-option",
+			Usage: "set DNS options",
+		},
+		cli.StringSliceFlag{
+			Name:  "add-host",
+			Usage: "add a custom host-to-IP mapping, format is host:ip",
+		},
+		cli.BoolFlag{
+			Name:  "userns",
+			Usage: "run in a new user namespace, container root is mapped to a range from /etc/subuid and /etc/subgid",
+		},
+		cli.StringSliceFlag{
+			Name:  "cap-add",
+			Usage: "add linux capabilities, ALL for all capabilities",
+		},
+		cli.StringSliceFlag{
+			Name:  "cap-drop",
+			Usage: "drop linux capabilities, ALL for all capabilities",
+		},
+		cli.BoolFlag{
+			Name:  "privileged",
+			Usage: "give all capabilities to the container and disable seccomp",
+		},
+		cli.BoolFlag{
+			Name:  "read-only",
+			Usage: "mount the container's root filesystem as read only, /tmp and /run are writable tmpfs",
+		},
+		cli.StringSliceFlag{
+			Name:  "device",
+			Usage: "add a host device to the container, format is /dev/xyz[:path][:rwm]",
+		},
+		cli.StringFlag{
+			Name:  "shm-size",
+			Usage: "size of /dev/shm, default is 64m",
+		},
+		cli.BoolFlag{
+			Name:  "init",
+			Usage: "run an init inside the container that forwards signals and reaps processes",
+		},
+		cli.StringFlag{
+			Name:  "u, user",
+			Usage: "username or uid, format is <name|uid>[:<group|gid>]",
+		},
+		cli.StringSliceFlag{
+			Name:  "group-add",
+			Usage: "add additional groups to join",
+		},
+		cli.StringFlag{
+			Name:  "w, workdir",
+			Usage: "working directory inside the container",
+		},
 	},
 	/*
 		这里是 run 命令执行的真正函数
@@ -69,7 +223,8 @@ var runCommand = cli.Command{
 		3. 调用 Run function 去准备启动容器
 	*/
 	Action: func(context *cli.Context) error {
-		if len(context.Args()) < 1 {
+		bundle := context.String("bundle")
+		if len(context.Args()) < 1 && bundle == "" {
 			return fmt.Errorf("missing container command")
 		}
 
@@ -78,33 +233,105 @@ var runCommand = cli.Command{
 			cmdList = append(cmdList, arg)
 		}
 
-		imageName := cmdList[0]
-		cmdList = cmdList[1:]
-
-		// 查找是否有上面定义的 BoolFlag "it"
-		tty := context.Bool("it")
-		detach := context.Bool("d")
+		opts := &RunOptions{
+			ContainerName: context.String("name"),
+			Network:       context.String("net"),
+			PortMapping:   context.StringSlice("p"),
+			IPC:           context.String("ipc"),
+			PID:           context.String("pid"),
+			UTS:           context.String("uts"),
+			Pod:           context.String("pod"),
+			TimeOffset:    context.String("time-offset"),
+			CgroupParent:  context.String("cgroup-parent"),
+		}
+		if err := checkCgroupParent(opts.CgroupParent); err != nil {
+			return err
+		}
+		if bundle != "" {
+			// 镜像、命令、资源限制等都从 bundle 的 config.json 中读取
+			if err := loadBundle(bundle, opts); err != nil {
+				return err
+			}
+		} else {
+			opts.ImageName = cmdList[0]
+			opts.CmdList = cmdList[1:]
+			opts.TTY = context.Bool("t")
+			opts.Resource = &subsystems.ResourceConfig{
+				CpuCfsQuota: context.Int("cpu"),
+				CpuShare:    context.String("cpushare"),
+				CpuSet:      context.String("cpuset"),
+				MemoryLimit: context.String("mem"),
+				PidsLimit:   context.Int64("pids-limit"),
+			}
+			if err := parseBlkio(context, opts.Resource); err != nil {
+				return err
+			}
+			opts.Volume = context.String("v")
+			opts.Env = context.StringSlice("e")
+			opts.EnvFiles = context.StringSlice("env-file")
+			opts.Seccomp = seccomp.DefaultProfile()
+			opts.CapAdd = context.StringSlice("cap-add")
+			opts.CapDrop = context.StringSlice("cap-drop")
+			opts.Privileged = context.Bool("privileged")
+			opts.ReadOnly = context.Bool("read-only")
+			opts.Devices = context.StringSlice("device")
+			opts.ShmSize = context.String("shm-size")
+			opts.UseInit = context.Bool("init")
+			opts.User = context.String("user")
+			opts.GroupAdd = context.StringSlice("group-add")
+			opts.Workdir = context.String("workdir")
+			opts.Ulimits = context.StringSlice("ulimit")
+			opts.Sysctls = context.StringSlice("sysctl")
+			opts.Hostname = context.String("hostname")
+			opts.Domainname = context.String("domainname")
+			opts.DNS = context.StringSlice("dns")
+			opts.DNSSearch = context.StringSlice("dns-search")
+			opts.DNSOptions = context.StringSlice("dns-option")
+			opts.ExtraHosts = context.StringSlice("add-host")
+			if err := checkWorkdir(opts.Workdir); err != nil {
+				return err
+			}
+			if opts.Privileged {
+				opts.Seccomp = nil
+			}
+		}
+		if err := applySecurityOpts(opts, context.StringSlice("security-opt")); err != nil {
+			return err
+		}
+		// --hook 在 bundle 中的同阶段 hook 之后执行
+		for _, value := range context.StringSlice("hook") {
+			if opts.Hooks == nil {
+				opts.Hooks = &oci.Hooks{}
+			}
+			if err := opts.Hooks.AddHook(value, context.Int("hook-timeout")); err != nil {
+				return err
+			}
+		}
+		opts.UserNS = opts.UserNS || context.Bool("userns")
+		if container.Rootless() {
+			// 非 root 用户只有在自己的 user namespace 中才有权限创建其他 namespace
+			if !opts.UserNS {
+				return fmt.Errorf("rootless mode requires --userns")
+			}
+			if (opts.Network != "" && !sharedNamespace(opts.Network)) || len(opts.PortMapping) > 0 {
+				return fmt.Errorf("--net <network> and -p are not supported in rootless mode, slirp4netns is used instead")
+			}
+		}
+		opts.Interactive = opts.Interactive || context.Bool("i")
+		// -i 和 -t 都没有时和原来一样在后台运行
+		opts.Detach = context.Bool("d") || !opts.TTY && !opts.Interactive
 
 		// tty 和 detach 不能同时提供
-		if tty && detach {
-			return fmt.Errorf("-it and -d parameter can not use together")
+		if (opts.TTY || opts.Interactive) && context.Bool("d") {
+			return fmt.Errorf("-i and -t parameter can not be used with -d")
 		}
-		log.Infof("createTty %v", tty)
+		log.Infof("createTty %v", opts.TTY)
 
-		cfg := &subsystems.ResourceConfig{
-			CpuCfsQuota: context.Int("cpu"),
-			CpuShare:    context.String("cpushare"),
-			CpuSet:      context.String("cpuset"),
-			MemoryLimit: context.String("mem"),
+		// log.Info("Config: ", opts.Resource)
+		// 前台运行时和 docker run 一样以容器的退出码退出
+		if code := Run(opts); code != 0 {
+			os.Exit(code)
 		}
-		// log.Info("Config: ", cfg)
-		volume := context.String("v")
-		envSlice := context.StringSlice("e")
-		containerName := context.String("name")
-		network := context.String("net")
-		portMapping := context.StringSlice("p")
-
-		Run(tty, cmdList, cfg, volume, containerName, imageName, envSlice, network, portMapping)
 		return nil
 	},
 }
@@ -117,12 +344,200 @@ var initCommand = cli.Command{
 		2. 执行容器初始化操作
 	*/
 	Action: func(context *cli.Context) error {
+		// 容器的 stdout 只留给用户进程，init 的日志写到 stderr
+		log.SetOutput(os.Stderr)
 		log.Infof("init come on")
 		err := container.RunContainerInitProcess()
 		return err
 	},
 }
 
+var podPauseCommand = cli.Command{
+	Name:   "pod-pause",
+	Usage:  "Pause process holding the namespaces of a pod. Do not call it outside",
+	Hidden: true,
+	Action: func(context *cli.Context) error {
+		return container.RunPauseProcess()
+	},
+}
+
+var podCommand = cli.Command{
+	Name:  "pod",
+	Usage: "pod commands, containers in a pod share network, IPC and UTS namespaces",
+	Subcommands: []cli.Command{
+		{
+			Name:  "create",
+			Usage: "create a pod, mydocker pod create [--net network] [-p port] name",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "net",
+					Usage: "pod network",
+				},
+				cli.StringSliceFlag{
+					Name:  "p",
+					Usage: "port mapping, applies to all containers in the pod",
+				},
+				cli.StringFlag{
+					Name:  "hostname",
+					Usage: "host name of the pod, default is the pod name",
+				},
+			},
+			Action: func(context *cli.Context) error {
+				if len(context.Args()) < 1 {
+					return fmt.Errorf("missing pod name")
+				}
+				return createPod(&PodOptions{
+					Name:        context.Args().Get(0),
+					Hostname:    context.String("hostname"),
+					Network:     context.String("net"),
+					PortMapping: context.StringSlice("p"),
+				})
+			},
+		},
+		{
+			Name:  "ps",
+			Usage: "list pods",
+			Action: func(context *cli.Context) error {
+				ListPods()
+				return nil
+			},
+		},
+		{
+			Name:  "start",
+			Usage: "start a stopped pod",
+			Action: func(context *cli.Context) error {
+				if len(context.Args()) < 1 {
+					return fmt.Errorf("missing pod name")
+				}
+				return startPod(context.Args().Get(0))
+			},
+		},
+		{
+			Name:  "stop",
+			Usage: "stop a pod and all containers in it",
+			Action: func(context *cli.Context) error {
+				if len(context.Args()) < 1 {
+					return fmt.Errorf("missing pod name")
+				}
+				return stopPod(context.Args().Get(0))
+			},
+		},
+		{
+			Name:  "rm",
+			Usage: "remove a stopped pod and its containers",
+			Action: func(context *cli.Context) error {
+				if len(context.Args()) < 1 {
+					return fmt.Errorf("missing pod name")
+				}
+				return removePod(context.Args().Get(0))
+			},
+		},
+	},
+}
+
+var specCommand = cli.Command{
+	Name:  "spec",
+	Usage: "create a new OCI specification file config.json",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "bundle, b",
+			Value: ".",
+			Usage: "path to the bundle directory",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		return writeSpec(context.String("bundle"))
+	},
+}
+
+// 下面的 create、start、state、kill、delete 命令和 runc 的命令行兼容，
+// 可以让 mydocker 作为上层容器管理程序的 OCI runtime 使用
+var createCommand = cli.Command{
+	Name:      "create",
+	Usage:     "create a container from an OCI bundle, the user process runs after start",
+	ArgsUsage: "<container-id>",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "bundle, b",
+			Value: ".",
+			Usage: "path to the bundle directory",
+		},
+		cli.StringFlag{
+			Name:  "pid-file",
+			Usage: "file to write the container init process pid to",
+		},
+		cli.StringFlag{
+			Name:  "console-socket",
+			Usage: "unix socket to receive the pty master fd when process.terminal is true",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("missing container id")
+		}
+		return createOCIContainer(context.Args().Get(0), context.String("bundle"),
+			context.String("pid-file"), context.String("console-socket"))
+	},
+}
+
+var startCommand = cli.Command{
+	Name:      "start",
+	Usage:     "run the user process in a created container",
+	ArgsUsage: "<container-id>",
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("missing container id")
+		}
+		return startOCIContainer(context.Args().Get(0))
+	},
+}
+
+var stateCommand = cli.Command{
+	Name:      "state",
+	Usage:     "output the OCI state of a container",
+	ArgsUsage: "<container-id>",
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("missing container id")
+		}
+		return printState(context.Args().Get(0))
+	},
+}
+
+var killCommand = cli.Command{
+	Name:      "kill",
+	Usage:     "send a signal to the container init process, default SIGTERM",
+	ArgsUsage: "<container-id> [signal]",
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("missing container id")
+		}
+		signal := context.Args().Get(1)
+		if signal == "" {
+			signal = "SIGTERM"
+		}
+		return killContainer(context.Args().Get(0), signal)
+	},
+}
+
+var deleteCommand = cli.Command{
+	Name:      "delete",
+	Usage:     "delete a stopped container",
+	ArgsUsage: "<container-id>",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "force, f",
+			Usage: "kill the container if it is still running",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("missing container id")
+		}
+		return deleteContainer(context.Args().Get(0), context.Bool("force"))
+	},
+}
+
 var commitCommand = cli.Command{
 	Name:  "commit",
 	Usage: "commit container into image",
@@ -145,6 +560,18 @@ var listCommand = cli.Command{
 	},
 }
 
+var inspectCommand = cli.Command{
+	Name:      "inspect",
+	Usage:     "display detailed information of a container",
+	ArgsUsage: "<container-name>",
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("missing container name")
+		}
+		return inspectContainer(context.Args().Get(0))
+	},
+}
+
 var logCommand = cli.Command{
 	Name:  "logs",
 	Usage: "print logs of a container",
@@ -161,11 +588,37 @@ var logCommand = cli.Command{
 var execCommand = cli.Command{
 	Name:  "exec",
 	Usage: "exec a command into container",
+	Flags: []cli.Flag{
+		cli.StringSliceFlag{
+			Name:  "cap-add",
+			Usage: "add linux capabilities to the container capabilities",
+		},
+		cli.StringSliceFlag{
+			Name:  "cap-drop",
+			Usage: "drop linux capabilities from the container capabilities",
+		},
+		cli.BoolFlag{
+			Name:  "privileged",
+			Usage: "give all capabilities to the command and disable seccomp",
+		},
+		cli.StringFlag{
+			Name:  "u, user",
+			Usage: "username or uid, format is <name|uid>[:<group|gid>]",
+		},
+		cli.StringSliceFlag{
+			Name:  "group-add",
+			Usage: "add additional groups to join",
+		},
+		cli.StringFlag{
+			Name:  "w, workdir",
+			Usage: "working directory inside the container",
+		},
+	},
 	Action: func(context *cli.Context) error {
-		// 如果存在环境变量，则说明 C 代码已经运行过了，setns 已经执行过了，直接返回
+		// 如果存在环境变量，则说明 C 代码已经运行过了，当前进程已经在容器的 namespace 中
 		if os.Getenv(EnvExecPid) != "" {
-			log.Infof("pid callback pid %v", os.Getgid())
-			return nil
+			log.SetOutput(os.Stderr)
+			return container.RunContainerExecProcess()
 		}
 		// mydocker exec container_name command
 		if len(context.Args()) < 2 {
@@ -175,8 +628,141 @@ var execCommand = cli.Command{
 		// 除了容器之外的参数作为命令
 		var cmdList []string
 		cmdList = append(cmdList, context.Args().Tail()...)
-		ExecContainer(containerName, cmdList)
+		if err := checkWorkdir(context.String("workdir")); err != nil {
+			return err
+		}
+		return ExecContainer(containerName, cmdList, &ExecOptions{
+			CapAdd:     context.StringSlice("cap-add"),
+			CapDrop:    context.StringSlice("cap-drop"),
+			Privileged: context.Bool("privileged"),
+			User:       context.String("user"),
+			GroupAdd:   context.StringSlice("group-add"),
+			Workdir:    context.String("workdir"),
+		})
+	},
+}
+
+// checkWorkdir -w 指定的工作目录必须是容器内的绝对路径
+func checkWorkdir(workdir string) error {
+	if workdir != "" && !filepath.IsAbs(workdir) {
+		return fmt.Errorf("working directory %s must be an absolute path", workdir)
+	}
+	return nil
+}
+
+// parseBlkio 解析 --blkio-weight 和 --device-read-bps 这类参数，设备号从宿主机的设备文件读取
+func parseBlkio(context *cli.Context, cfg *subsystems.ResourceConfig) error {
+	if weight := context.Int("blkio-weight"); weight != 0 {
+		if weight < 10 || weight > 1000 {
+			return fmt.Errorf("invalid blkio weight %d, should be between 10 and 1000", weight)
+		}
+		cfg.BlkioWeight = uint16(weight)
+	}
+	throttles := []struct {
+		flag  string
+		bps   bool
+		rules *[]string
+	}{
+		{"device-read-bps", true, &cfg.ReadBpsDevice},
+		{"device-write-bps", true, &cfg.WriteBpsDevice},
+		{"device-read-iops", false, &cfg.ReadIOpsDevice},
+		{"device-write-iops", false, &cfg.WriteIOpsDevice},
+	}
+	for _, t := range throttles {
+		rules, err := container.ParseThrottleDevices(context.StringSlice(t.flag), t.bps)
+		if err != nil {
+			return fmt.Errorf("--%s: %v", t.flag, err)
+		}
+		*t.rules = rules
+	}
+	return nil
+}
+
+// checkCgroupParent --cgroup-parent 必须是相对于 cgroup 根节点的路径，不能通过 .. 跳出 cgroup 文件系统
+func checkCgroupParent(parent string) error {
+	if parent == "" {
 		return nil
+	}
+	if !filepath.IsLocal(parent) {
+		return fmt.Errorf("invalid cgroup parent %s, it must be a relative path without ..", parent)
+	}
+	return nil
+}
+
+var updateCommand = cli.Command{
+	Name:      "update",
+	Usage:     "update resource limits of a running container",
+	ArgsUsage: "<container-name>",
+	Flags: []cli.Flag{
+		cli.IntFlag{
+			Name:  "cpu",
+			Usage: "cpu quota",
+		},
+		cli.StringFlag{
+			Name:  "cpushare",
+			Usage: "set cpu share",
+		},
+		cli.StringFlag{
+			Name:  "cpuset",
+			Usage: "set cpu set",
+		},
+		cli.StringFlag{
+			Name:  "mem",
+			Usage: "set memory limit",
+		},
+		cli.Int64Flag{
+			Name:  "pids-limit",
+			Usage: "limit the number of processes in the container, -1 for unlimited",
+		},
+		cli.IntFlag{
+			Name:  "blkio-weight",
+			Usage: "block IO weight, between 10 and 1000",
+		},
+		cli.StringSliceFlag{
+			Name:  "device-read-bps",
+			Usage: "limit read rate from a device, e.g. /dev/sda:10mb",
+		},
+		cli.StringSliceFlag{
+			Name:  "device-write-bps",
+			Usage: "limit write rate to a device, e.g. /dev/sda:10mb",
+		},
+		cli.StringSliceFlag{
+			Name:  "device-read-iops",
+			Usage: "limit read IO per second from a device, e.g. /dev/sda:1000",
+		},
+		cli.StringSliceFlag{
+			Name:  "device-write-iops",
+			Usage: "limit write IO per second to a device, e.g. /dev/sda:1000",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("missing container name")
+		}
+		// 只修改指定了的限制
+		cfg := &subsystems.ResourceConfig{
+			CpuCfsQuota: context.Int("cpu"),
+			CpuShare:    context.String("cpushare"),
+			CpuSet:      context.String("cpuset"),
+			MemoryLimit: context.String("mem"),
+			PidsLimit:   context.Int64("pids-limit"),
+		}
+		if err := parseBlkio(context, cfg); err != nil {
+			return err
+		}
+		return updateContainer(context.Args().Get(0), cfg)
+	},
+}
+
+var statsCommand = cli.Command{
+	Name:      "stats",
+	Usage:     "print memory and process usage of running containers",
+	ArgsUsage: "<container-name> [container-name...]",
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("missing container name")
+		}
+		return containerStats(context.Args())
 	},
 }
 
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"syscall"
+
+	"mydocker/container"
+
+	"github.com/pkg/errors"
+)
+
+// --net、--ipc、--pid、--uts 的取值
+const (
+	namespacePrivate         = "private"
+	namespaceHost            = "host"
+	namespaceContainerPrefix = "container:"
+)
+
+// namespaceMode 一种 namespace 的共享方式
+type namespaceMode struct {
+	flag  uintptr
+	name  string // 参数名
+	value string // private、host 或 container:<name>
+}
+
+// setUpNamespaces 根据 --net、--ipc、--pid、--uts 生成容器的 clone flag 和要加入的已有 namespace
+/*
+	private 创建新的 namespace，host 使用宿主机的 namespace，也就是不创建，
+	container:<name> 加入另一个容器的 namespace，由 container.JoinNamespaces 在启动容器进程时加入。
+	--net 的其他取值是网络名，容器仍然有自己的 network namespace。
+*/
+func setUpNamespaces(opts *RunOptions) error {
+	if opts.Cloneflags == 0 {
+		opts.Cloneflags = container.DefaultCloneflags
+	}
+	modes := []namespaceMode{
+		{syscall.CLONE_NEWIPC, "ipc", opts.IPC},
+		{syscall.CLONE_NEWPID, "pid", opts.PID},
+		{syscall.CLONE_NEWUTS, "uts", opts.UTS},
+	}
+	if sharedNamespace(opts.Network) {
+		modes = append(modes, namespaceMode{syscall.CLONE_NEWNET, "net", opts.Network})
+	}
+	if opts.Pod != "" {
+		if err := joinPod(opts); err != nil {
+			return err
+		}
+	}
+	for _, m := range modes {
+		switch {
+		case m.value == "" || m.value == namespacePrivate:
+			continue
+		case m.value == namespaceHost:
+			opts.Cloneflags &^= m.flag
+		case strings.HasPrefix(m.value, namespaceContainerPrefix) && m.value != namespaceContainerPrefix:
+			name := strings.TrimPrefix(m.value, namespaceContainerPrefix)
+			// 非 root 用户没有权限进入其他 user namespace 中的 namespace
+			if container.Rootless() {
+				return fmt.Errorf("--%s %s is not supported in rootless mode", m.name, m.value)
+			}
+			info, err := getContainerInfoByName(name)
+			if err != nil {
+				return errors.Wrapf(err, "get container %s info", name)
+			}
+			if info.Status != container.RUNNING {
+				return fmt.Errorf("container %s is not running", name)
+			}
+			opts.Cloneflags &^= m.flag
+			opts.Namespaces = append(opts.Namespaces, container.NewNamespacePath(info.Pid, m.flag))
+		default:
+			return fmt.Errorf("invalid --%s %s, should be private, host or container:<name>", m.name, m.value)
+		}
+	}
+	// 新的 user namespace 中没有权限挂载不属于它的 PID namespace 的 proc
+	if opts.UserNS && opts.Cloneflags&syscall.CLONE_NEWPID == 0 {
+		return errors.New("--pid host or container:<name> can not be used with a new user namespace")
+	}
+	if opts.Cloneflags&syscall.CLONE_NEWNET == 0 && len(opts.PortMapping) > 0 {
+		return errors.New("-p can not be used when the container shares the network namespace")
+	}
+	// 没有自己的 UTS namespace 时修改 hostname 会影响宿主机或其他容器，hostname 沿用它们的
+	if opts.Cloneflags&syscall.CLONE_NEWUTS == 0 {
+		if opts.Hostname != "" || opts.Domainname != "" {
+			return errors.New("--hostname and --domainname can not be used when the container shares the UTS namespace")
+		}
+		hostname, err := container.NamespaceHostname(opts.Namespaces)
+		if err != nil {
+			return errors.Wrap(err, "get hostname of the UTS namespace")
+		}
+		opts.Hostname = hostname
+	}
+	return nil
+}
+
+// joinPod 加入 pod 的 pause 进程持有的 namespace，pod 中的容器不能再单独指定这些 namespace 和端口映射
+func joinPod(opts *RunOptions) error {
+	if container.Rootless() {
+		return errors.New("pod is not supported in rootless mode")
+	}
+	if opts.Network != "" || opts.IPC != "" || opts.UTS != "" || len(opts.PortMapping) > 0 {
+		return errors.New("--net, --ipc, --uts and -p can not be used with --pod, they are set on the pod")
+	}
+	pod, err := getPodInfoByName(opts.Pod)
+	if err != nil {
+		return errors.Wrapf(err, "get pod %s info", opts.Pod)
+	}
+	if pod.Status != container.RUNNING {
+		return fmt.Errorf("pod %s is not running", opts.Pod)
+	}
+	for _, flag := range []uintptr{syscall.CLONE_NEWNET, syscall.CLONE_NEWIPC, syscall.CLONE_NEWUTS} {
+		opts.Cloneflags &^= flag
+		opts.Namespaces = append(opts.Namespaces, container.NewNamespacePath(pod.Pid, flag))
+	}
+	return nil
+}
+
+// sharedNamespace 判断取值是否表示和宿主机或其他容器共享 namespace
+func sharedNamespace(value string) bool {
+	return value == namespaceHost || strings.HasPrefix(value, namespaceContainerPrefix)
+}
@@ -77,7 +77,12 @@ func (d *BridgeNetworkDriver) Connect(network *Network, endpoint *Endpoint) erro
 }
 
 func (d *BridgeNetworkDriver) Disconnect(network Network, endpoint *Endpoint) error {
-	return nil
+	// 容器的 network namespace 销毁时 veth 会一起删除，只有容器进程还在时才需要手动删除
+	veth, err := netlink.LinkByName(endpoint.ID[:5])
+	if err != nil {
+		return nil
+	}
+	return netlink.LinkDel(veth)
 }
 
 // 初始化 Linux Bridge
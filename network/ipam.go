@@ -55,7 +55,7 @@ func (ipam *IPAM) dump() error {
 		if !os.IsNotExist(err) {
 			return err
 		}
-		if err = os.MkdirAll(ipamConfigFileDir, constant.Perm0644); err != nil {
+		if err = os.MkdirAll(ipamConfigFileDir, constant.Perm0755); err != nil {
 			return err
 		}
 	}
@@ -55,7 +55,7 @@ func (nw *Network) dump(dumpPath string) error {
 		if !os.IsNotExist(err) {
 			return err
 		}
-		if err = os.MkdirAll(dumpPath, constant.Perm0644); err != nil {
+		if err = os.MkdirAll(dumpPath, constant.Perm0755); err != nil {
 			return errors.Wrapf(err, "create network dump path %s failed", dumpPath)
 		}
 	}
@@ -117,7 +117,7 @@ func Init() error {
 		if !os.IsNotExist(err) {
 			return err
 		}
-		if err = os.MkdirAll(defaultNetworkPath, constant.Perm0644); err != nil {
+		if err = os.MkdirAll(defaultNetworkPath, constant.Perm0755); err != nil {
 			return err
 		}
 	}
@@ -300,6 +300,11 @@ func configEndpointIpAddressAndRoute(ep *Endpoint, info *container.Info) error {
 
 // configPortMapping 配置端口映射
 func configPortMapping(ep *Endpoint) error {
+	return iptablesPortMapping(ep, "-A")
+}
+
+// iptablesPortMapping 添加（-A）或删除（-D）端口映射对应的 DNAT 规则
+func iptablesPortMapping(ep *Endpoint, action string) error {
 	var err error
 	// 遍历容器端口映射列表
 	for _, pm := range ep.PortMapping {
@@ -312,8 +317,8 @@ func configPortMapping(ep *Endpoint) error {
 		// 由于 iptables 没有 Go 语言版本的实现，所以采用 exec.Command 的方式直接调用命令配置
 		// 在 iptables 的 PREROUTING 中添加 DNAT 规则
 		// 将宿主机的端口请求转发到容器的地址和端口上
-		iptablesCmd := fmt.Sprintf("-t nat -A PREROUTING -p tcp -m tcp --dport %s -j DNAT --to-destination %s:%s",
-			portMapping[0], ep.IPAddress.String(), portMapping[1])
+		iptablesCmd := fmt.Sprintf("-t nat %s PREROUTING -p tcp -m tcp --dport %s -j DNAT --to-destination %s:%s",
+			action, portMapping[0], ep.IPAddress.String(), portMapping[1])
 		cmd := exec.Command("iptables", strings.Split(iptablesCmd, " ")...)
 		logrus.Infoln("配置端口映射 cmd:", cmd.String())
 		// 执行 iptables 命令,添加端口映射转发规则
@@ -347,6 +352,8 @@ func Connect(networkName string, info *container.Info) error {
 		Network:     network,
 		PortMapping: info.PortMapping,
 	}
+	info.IP = ip.String()
+	info.Network = networkName
 	// 调用网络驱动挂载和配置网络端点
 	if err = drivers[network.Driver].Connect(network, ep); err != nil {
 		return err
@@ -359,6 +366,27 @@ func Connect(networkName string, info *container.Info) error {
 	return configPortMapping(ep)
 }
 
+// Disconnect 断开容器和网络的连接，删除端口映射和 veth，然后释放容器的 IP
 func Disconnect(networkName string, info *container.Info) error {
-	return nil
+	network, ok := networks[networkName]
+	if !ok {
+		return fmt.Errorf("no Such Network: %s", networkName)
+	}
+	ip := net.ParseIP(info.IP)
+	if ip == nil {
+		return fmt.Errorf("invalid container ip %s", info.IP)
+	}
+	ep := &Endpoint{
+		ID:          fmt.Sprintf("%s-%s", info.Id, networkName),
+		IPAddress:   ip,
+		Network:     network,
+		PortMapping: info.PortMapping,
+	}
+	if err := iptablesPortMapping(ep, "-D"); err != nil {
+		logrus.Errorf("delete port mapping err: %v", err)
+	}
+	if err := drivers[network.Driver].Disconnect(*network, ep); err != nil {
+		return err
+	}
+	return errors.Wrapf(ipAllocator.Release(network.IPRange, &ip), "release ip %s", info.IP)
 }
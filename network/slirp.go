@@ -0,0 +1,59 @@
+package network
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	slirpBinary = "slirp4netns"
+	slirpTap    = "tap0"
+	slirpMTU    = "65520"
+
+	// SlirpIP slirp4netns --configure 分配给容器的 IP
+	SlirpIP = "10.0.2.100"
+	// SlirpDNS slirp4netns 内置的 DNS 转发地址，会转发给宿主机的 DNS
+	SlirpDNS = "10.0.2.3"
+)
+
+// StartSlirp 为 rootless 容器启动 slirp4netns，在用户态为容器的 net namespace 提供网络
+/*
+	非 root 用户无法创建 veth 和网桥，slirp4netns 会在容器的 net namespace 中创建 tap0 设备，
+	并在用户态完成 TCP/IP 协议栈的转发，容器默认网关为 10.0.2.2。
+	slirp4netns 在后台运行，返回其 pid，容器删除时需要调用 StopSlirp 结束它。
+	没有安装 slirp4netns 时容器只有 loopback 网络，不会报错。
+*/
+func StartSlirp(pid int, logFile *os.File) (int, error) {
+	path, err := exec.LookPath(slirpBinary)
+	if err != nil {
+		logrus.Warnf("%s not found, rootless container only has loopback network", slirpBinary)
+		return 0, nil
+	}
+	cmd := exec.Command(path, "--configure", "--mtu="+slirpMTU, "--disable-host-loopback", strconv.Itoa(pid), slirpTap)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if logFile != nil {
+		cmd.Stdout = logFile
+		cmd.Stderr = logFile
+	}
+	if err = cmd.Start(); err != nil {
+		return 0, errors.Wrapf(err, "start %s", slirpBinary)
+	}
+	// 不等待 slirp4netns 退出，释放子进程资源即可
+	go func() { _ = cmd.Wait() }()
+	return cmd.Process.Pid, nil
+}
+
+// StopSlirp 结束容器对应的 slirp4netns 进程
+func StopSlirp(pid int) {
+	if pid <= 0 {
+		return
+	}
+	if err := syscall.Kill(pid, syscall.SIGTERM); err != nil && err != syscall.ESRCH {
+		logrus.Warnf("kill %s %d error %v", slirpBinary, pid, err)
+	}
+}
@@ -10,6 +10,8 @@ package nsenter
 #include <stdlib.h>
 #include <string.h>
 #include <fcntl.h>
+#include <sys/stat.h>
+#include <sys/wait.h>
 __attribute__((constructor)) void enter_namespace(void) {
    // 这里的代码会在 Go 运行时启动前执行，它会在单线程的 C 上下文中运行
 	char *mydocker_pid;
@@ -21,20 +23,26 @@ __attribute__((constructor)) void enter_namespace(void) {
 		// 如果没有指定 PID 就不需要继续执行，直接退出
 		return;
 	}
-	char *mydocker_cmd;
-	mydocker_cmd = getenv("mydocker_cmd");
-	if (mydocker_cmd) {
-		// fprintf(stdout, "got mydocker_cmd=%s\n", mydocker_cmd);
-	} else {
-		// fprintf(stdout, "missing mydocker_cmd env skip nsenter");
-		// 如果没有指定命令也是直接退出
-		return;
-	}
 	int i;
 	char nspath[1024];
-	// 需要进入的5种namespace
-	char *namespaces[] = { "ipc", "uts", "net", "pid", "mnt" };
-	for (i=0; i<5; i++) {
+	// 容器运行在自己的 user namespace 中时，必须先进入 user namespace 才有权限进入其他 namespace
+	// 进入自己所在的 user namespace 会返回 EINVAL，所以只有不同时才进入
+	int userns = 0;
+	struct stat self_ns, target_ns;
+	sprintf(nspath, "/proc/%s/ns/user", mydocker_pid);
+	if (stat("/proc/self/ns/user", &self_ns) == 0 && stat(nspath, &target_ns) == 0 && self_ns.st_ino != target_ns.st_ino) {
+		int fd = open(nspath, O_RDONLY);
+		if (setns(fd, CLONE_NEWUSER) == -1) {
+			//fprintf(stderr, "setns on user namespace failed: %s\n", strerror(errno));
+		} else {
+			userns = 1;
+		}
+		close(fd);
+	}
+	// 需要进入的7种namespace，cgroup namespace 要在 mnt 之前进入
+	// 使用 --init 时 PID 1 自己没有进入 time namespace，要用它的 time_for_children
+	char *namespaces[] = { "ipc", "uts", "net", "pid", "cgroup", "time_for_children", "mnt" };
+	for (i=0; i<7; i++) {
 		// 拼接对应路径，类似于/proc/pid/ns/ipc这样
 		sprintf(nspath, "/proc/%s/ns/%s", mydocker_pid, namespaces[i]);
 		int fd = open(nspath, O_RDONLY);
@@ -46,10 +54,32 @@ __attribute__((constructor)) void enter_namespace(void) {
 		}
 		close(fd);
 	}
-	// 在进入的 Namespace 中执行指定命令，然后退出
-	int res = system(mydocker_cmd);
-	exit(0);
-	return;
+	// 进入 user namespace 之后切换为容器内的 root，否则会以 overflow uid 运行
+	if (userns) {
+		setgid(0);
+		setuid(0);
+	}
+	// 进入 pid namespace 只对子进程生效，所以这里 fork 一次，子进程回到 Go 运行时，
+	// 从 fd 3 读取 InitSpec 并设置用户、capability 和 seccomp 之后执行用户命令
+	pid_t child = fork();
+	if (child == -1) {
+		fprintf(stderr, "nsenter: fork failed: %s\n", strerror(errno));
+		exit(1);
+	}
+	if (child == 0) {
+		return;
+	}
+	// 父进程等待子进程退出，并以相同的状态退出
+	int status;
+	while (waitpid(child, &status, 0) == -1) {
+		if (errno != EINTR) {
+			exit(1);
+		}
+	}
+	if (WIFSIGNALED(status)) {
+		exit(128 + WTERMSIG(status));
+	}
+	exit(WEXITSTATUS(status));
 }
 */
 import "C"
@@ -0,0 +1,89 @@
+package oci
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// RunHooks 依次执行 hooks，每个 hook 从 stdin 读取容器的 state，任意一个失败则返回错误
+func RunHooks(hooks []Hook, state *State) error {
+	if len(hooks) == 0 {
+		return nil
+	}
+	stateJSON, err := json.Marshal(state)
+	if err != nil {
+		return errors.Wrap(err, "marshal state")
+	}
+	for _, h := range hooks {
+		if err = runHook(h, stateJSON); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddHook 解析 --hook <stage>=<path> 并加入对应阶段，timeout 为每个 hook 的超时时间，单位为秒
+/*
+	createContainer 和 startContainer 需要在容器的 mount namespace 中执行，mydocker 没有实现，
+	所以只支持 prestart、createRuntime、poststart 和 poststop。
+*/
+func (h *Hooks) AddHook(value string, timeout int) error {
+	stage, path, ok := strings.Cut(value, "=")
+	if !ok || !filepath.IsAbs(path) {
+		return errors.Errorf("invalid hook %s, format should be <stage>=<absolute path>", value)
+	}
+	if timeout <= 0 {
+		return errors.Errorf("hook timeout must be positive, got %d", timeout)
+	}
+	hook := Hook{Path: path, Timeout: &timeout}
+	switch stage {
+	case "prestart":
+		h.Prestart = append(h.Prestart, hook)
+	case "createRuntime":
+		h.CreateRuntime = append(h.CreateRuntime, hook)
+	case "poststart":
+		h.Poststart = append(h.Poststart, hook)
+	case "poststop":
+		h.Poststop = append(h.Poststop, hook)
+	default:
+		return errors.Errorf("invalid hook stage %s, should be prestart, createRuntime, poststart or poststop", stage)
+	}
+	return nil
+}
+
+func runHook(h Hook, stateJSON []byte) error {
+	ctx := context.Background()
+	if h.Timeout != nil {
+		if *h.Timeout <= 0 {
+			return errors.Errorf("hook %s: timeout must be positive", h.Path)
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(*h.Timeout)*time.Second)
+		defer cancel()
+	}
+	cmd := exec.CommandContext(ctx, h.Path)
+	if len(h.Args) > 0 {
+		cmd.Args = h.Args
+	}
+	cmd.Env = h.Env
+	// hook 超时被 kill 之后，它的子进程可能还占着输出管道，不能一直等下去
+	cmd.WaitDelay = time.Second
+	cmd.Stdin = bytes.NewReader(stateJSON)
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return errors.Errorf("hook %s timed out after %ds", h.Path, *h.Timeout)
+		}
+		return errors.Wrapf(err, "hook %s: %s", h.Path, output.String())
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package oci
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestRunHooks(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "state.json")
+	hooks := []Hook{{Path: "/bin/sh", Args: []string{"sh", "-c", "cat > " + out}}}
+	state := &State{Version: Version, ID: "test", Status: StateCreating, Pid: 1, Bundle: "/bundle"}
+	if err := RunHooks(hooks, state); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := new(State)
+	if err = json.Unmarshal(content, got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, state) {
+		t.Fatalf("got state %+v, want %+v", got, state)
+	}
+}
+
+func TestRunHooksTimeout(t *testing.T) {
+	timeout := 1
+	hooks := []Hook{{Path: "/bin/sh", Args: []string{"sh", "-c", "sleep 5"}, Timeout: &timeout}}
+	if err := RunHooks(hooks, &State{}); err == nil {
+		t.Fatal("expect timeout error")
+	}
+}
+
+func TestAddHook(t *testing.T) {
+	hooks := &Hooks{}
+	for _, value := range []string{"prestart=/bin/a", "createRuntime=/bin/b", "poststart=/bin/c", "poststop=/bin/d", "prestart=/bin/e"} {
+		if err := hooks.AddHook(value, 10); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(hooks.Prestart) != 2 || hooks.Prestart[1].Path != "/bin/e" || *hooks.Prestart[1].Timeout != 10 ||
+		len(hooks.CreateRuntime) != 1 || len(hooks.Poststart) != 1 || len(hooks.Poststop) != 1 {
+		t.Errorf("AddHook got %+v", hooks)
+	}
+	for _, value := range []string{"prestart", "prestart=bin/a", "createContainer=/bin/a"} {
+		if err := hooks.AddHook(value, 10); err == nil {
+			t.Errorf("AddHook(%q) expect error", value)
+		}
+	}
+	if err := hooks.AddHook("prestart=/bin/a", 0); err == nil {
+		t.Error("AddHook with zero timeout expect error")
+	}
+}
@@ -0,0 +1,278 @@
+package oci
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// Version 支持的 OCI runtime-spec 版本
+const Version = "1.0.2"
+
+// SpecConfig bundle 中配置文件的文件名
+const SpecConfig = "config.json"
+
+// Spec OCI runtime-spec 中的 config.json，这里只定义了 mydocker 用得到的字段
+type Spec struct {
+	Version     string            `json:"ociVersion"`
+	Process     *Process          `json:"process,omitempty"`
+	Root        *Root             `json:"root,omitempty"`
+	Hostname    string            `json:"hostname,omitempty"`
+	Domainname  string            `json:"domainname,omitempty"`
+	Mounts      []Mount           `json:"mounts,omitempty"`
+	Hooks       *Hooks            `json:"hooks,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Linux       *Linux            `json:"linux,omitempty"`
+}
+
+// Process 容器内的用户进程
+type Process struct {
+	Terminal bool          `json:"terminal,omitempty"`
+	User     User          `json:"user"`
+	Args     []string      `json:"args"`
+	Env      []string      `json:"env,omitempty"`
+	Cwd      string        `json:"cwd"`
+	Rlimits  []POSIXRlimit `json:"rlimits,omitempty"`
+
+	Capabilities    *LinuxCapabilities `json:"capabilities,omitempty"`
+	NoNewPrivileges bool               `json:"noNewPrivileges,omitempty"`
+}
+
+// LinuxCapabilities 用户进程的各个 capability 集合，值为 CAP_NET_ADMIN 这样的名字
+type LinuxCapabilities struct {
+	Bounding    []string `json:"bounding,omitempty"`
+	Effective   []string `json:"effective,omitempty"`
+	Inheritable []string `json:"inheritable,omitempty"`
+	Permitted   []string `json:"permitted,omitempty"`
+	Ambient     []string `json:"ambient,omitempty"`
+}
+
+// User 用户进程的 uid 和 gid
+type User struct {
+	UID            uint32   `json:"uid"`
+	GID            uint32   `json:"gid"`
+	AdditionalGids []uint32 `json:"additionalGids,omitempty"`
+}
+
+// POSIXRlimit 资源限制，Type 为 RLIMIT_NOFILE 这样的名字
+type POSIXRlimit struct {
+	Type string `json:"type"`
+	Hard uint64 `json:"hard"`
+	Soft uint64 `json:"soft"`
+}
+
+// Root 容器的 rootfs，Path 为相对 bundle 的路径
+type Root struct {
+	Path     string `json:"path"`
+	Readonly bool   `json:"readonly,omitempty"`
+}
+
+// Mount 挂载点
+type Mount struct {
+	Destination string   `json:"destination"`
+	Type        string   `json:"type,omitempty"`
+	Source      string   `json:"source,omitempty"`
+	Options     []string `json:"options,omitempty"`
+}
+
+// Hooks 容器生命周期中各个阶段需要执行的 hook
+type Hooks struct {
+	Prestart        []Hook `json:"prestart,omitempty"`
+	CreateRuntime   []Hook `json:"createRuntime,omitempty"`
+	CreateContainer []Hook `json:"createContainer,omitempty"`
+	StartContainer  []Hook `json:"startContainer,omitempty"`
+	Poststart       []Hook `json:"poststart,omitempty"`
+	Poststop        []Hook `json:"poststop,omitempty"`
+}
+
+// Hook 一个 hook 程序，Args 包含 argv[0]，Timeout 单位为秒
+type Hook struct {
+	Path    string   `json:"path"`
+	Args    []string `json:"args,omitempty"`
+	Env     []string `json:"env,omitempty"`
+	Timeout *int     `json:"timeout,omitempty"`
+}
+
+// Linux Linux 平台相关的配置
+type Linux struct {
+	Resources  *LinuxResources  `json:"resources,omitempty"`
+	Namespaces []LinuxNamespace `json:"namespaces,omitempty"`
+	Seccomp    *LinuxSeccomp    `json:"seccomp,omitempty"`
+
+	MaskedPaths   []string          `json:"maskedPaths,omitempty"`
+	ReadonlyPaths []string          `json:"readonlyPaths,omitempty"`
+	Sysctl        map[string]string `json:"sysctl,omitempty"`
+
+	CgroupsPath string `json:"cgroupsPath,omitempty"` // 容器的 cgroup，相对于各个 hierarchy 的根节点
+}
+
+// LinuxSeccomp seccomp 配置，没有配置时容器不启用 seccomp
+type LinuxSeccomp struct {
+	DefaultAction   string         `json:"defaultAction"`
+	DefaultErrnoRet *uint          `json:"defaultErrnoRet,omitempty"`
+	Architectures   []string       `json:"architectures,omitempty"`
+	Syscalls        []LinuxSyscall `json:"syscalls,omitempty"`
+}
+
+// LinuxSyscall 一组系统调用的过滤规则
+type LinuxSyscall struct {
+	Names    []string          `json:"names"`
+	Action   string            `json:"action"`
+	ErrnoRet *uint             `json:"errnoRet,omitempty"`
+	Args     []LinuxSeccompArg `json:"args,omitempty"`
+}
+
+// LinuxSeccompArg 系统调用参数的匹配条件，Op 为 SCMP_CMP_EQ 这样的名字
+type LinuxSeccompArg struct {
+	Index    uint   `json:"index"`
+	Value    uint64 `json:"value"`
+	ValueTwo uint64 `json:"valueTwo,omitempty"`
+	Op       string `json:"op"`
+}
+
+// LinuxNamespace namespace 配置，Path 不为空时表示加入已有的 namespace
+type LinuxNamespace struct {
+	Type string `json:"type"`
+	Path string `json:"path,omitempty"`
+}
+
+// namespace 类型
+const (
+	PIDNamespace     = "pid"
+	NetworkNamespace = "network"
+	MountNamespace   = "mount"
+	IPCNamespace     = "ipc"
+	UTSNamespace     = "uts"
+	UserNamespace    = "user"
+	CgroupNamespace  = "cgroup"
+)
+
+// LinuxResources cgroup 资源限制
+type LinuxResources struct {
+	CPU     *LinuxCPU     `json:"cpu,omitempty"`
+	Memory  *LinuxMemory  `json:"memory,omitempty"`
+	Pids    *LinuxPids    `json:"pids,omitempty"`
+	BlockIO *LinuxBlockIO `json:"blockIO,omitempty"`
+}
+
+// LinuxCPU cpu 和 cpuset 限制
+type LinuxCPU struct {
+	Shares *uint64 `json:"shares,omitempty"`
+	Quota  *int64  `json:"quota,omitempty"`
+	Period *uint64 `json:"period,omitempty"`
+	Cpus   string  `json:"cpus,omitempty"`
+}
+
+// LinuxMemory 内存限制
+type LinuxMemory struct {
+	Limit *int64 `json:"limit,omitempty"`
+}
+
+// LinuxPids 进程数限制，小于等于 0 时不限制
+type LinuxPids struct {
+	Limit int64 `json:"limit"`
+}
+
+// LinuxBlockIO 块设备 I/O 的权重和限速
+type LinuxBlockIO struct {
+	Weight                  *uint16               `json:"weight,omitempty"`
+	ThrottleReadBpsDevice   []LinuxThrottleDevice `json:"throttleReadBpsDevice,omitempty"`
+	ThrottleWriteBpsDevice  []LinuxThrottleDevice `json:"throttleWriteBpsDevice,omitempty"`
+	ThrottleReadIOPSDevice  []LinuxThrottleDevice `json:"throttleReadIOPSDevice,omitempty"`
+	ThrottleWriteIOPSDevice []LinuxThrottleDevice `json:"throttleWriteIOPSDevice,omitempty"`
+}
+
+// LinuxThrottleDevice 一个块设备的限速
+type LinuxThrottleDevice struct {
+	Major int64  `json:"major"`
+	Minor int64  `json:"minor"`
+	Rate  uint64 `json:"rate"`
+}
+
+// Example 生成一份默认的 config.json，和 runc spec 生成的内容基本一致
+// 不同的是 terminal 为 false，这样 run -d 和不带 --console-socket 的 create 可以直接使用
+func Example() *Spec {
+	// 和 runc spec 一样只保留最基本的几个 capability
+	exampleCaps := []string{"CAP_AUDIT_WRITE", "CAP_KILL", "CAP_NET_BIND_SERVICE"}
+	return &Spec{
+		Version: Version,
+		Root: &Root{
+			Path: "rootfs",
+		},
+		Process: &Process{
+			User: User{},
+			Args: []string{"sh"},
+			Env: []string{
+				"PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin",
+				"TERM=xterm",
+			},
+			Cwd: "/",
+			Rlimits: []POSIXRlimit{
+				{Type: "RLIMIT_NOFILE", Hard: 1024, Soft: 1024},
+			},
+			Capabilities: &LinuxCapabilities{
+				Bounding:  exampleCaps,
+				Effective: exampleCaps,
+				Permitted: exampleCaps,
+			},
+			NoNewPrivileges: true,
+		},
+		Hostname: "mydocker",
+		Mounts: []Mount{
+			{Destination: "/proc", Type: "proc", Source: "proc"},
+			{Destination: "/dev", Type: "tmpfs", Source: "tmpfs", Options: []string{"nosuid", "strictatime", "mode=755", "size=65536k"}},
+			{Destination: "/dev/pts", Type: "devpts", Source: "devpts", Options: []string{"nosuid", "noexec", "newinstance", "ptmxmode=0666", "mode=0620"}},
+			{Destination: "/dev/shm", Type: "tmpfs", Source: "shm", Options: []string{"nosuid", "noexec", "nodev", "mode=1777", "size=65536k"}},
+			{Destination: "/dev/mqueue", Type: "mqueue", Source: "mqueue", Options: []string{"nosuid", "noexec", "nodev"}},
+			{Destination: "/sys", Type: "sysfs", Source: "sysfs", Options: []string{"nosuid", "noexec", "nodev", "ro"}},
+			{Destination: "/sys/fs/cgroup", Type: "cgroup", Source: "cgroup", Options: []string{"nosuid", "noexec", "nodev", "relatime", "ro"}},
+		},
+		Linux: &Linux{
+			Namespaces: []LinuxNamespace{
+				{Type: PIDNamespace},
+				{Type: NetworkNamespace},
+				{Type: IPCNamespace},
+				{Type: UTSNamespace},
+				{Type: MountNamespace},
+				{Type: CgroupNamespace},
+			},
+			MaskedPaths: []string{
+				"/proc/acpi", "/proc/asound", "/proc/kcore", "/proc/keys", "/proc/latency_stats",
+				"/proc/timer_list", "/proc/timer_stats", "/proc/sched_debug", "/sys/firmware", "/proc/scsi",
+			},
+			ReadonlyPaths: []string{
+				"/proc/bus", "/proc/fs", "/proc/irq", "/proc/sys", "/proc/sysrq-trigger",
+			},
+		},
+	}
+}
+
+// LoadSpec 读取 bundle 目录下的 config.json
+func LoadSpec(bundle string) (*Spec, error) {
+	configPath := filepath.Join(bundle, SpecConfig)
+	content, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read %s", configPath)
+	}
+	spec := new(Spec)
+	if err = json.Unmarshal(content, spec); err != nil {
+		return nil, errors.Wrapf(err, "unmarshal %s", configPath)
+	}
+	if spec.Process == nil || len(spec.Process.Args) == 0 {
+		return nil, errors.Errorf("%s: process.args must not be empty", configPath)
+	}
+	if spec.Root == nil || spec.Root.Path == "" {
+		return nil, errors.Errorf("%s: root.path must not be empty", configPath)
+	}
+	return spec, nil
+}
+
+// RootfsPath 返回 rootfs 的绝对路径，相对路径是相对 bundle 目录的
+func (s *Spec) RootfsPath(bundle string) string {
+	if filepath.IsAbs(s.Root.Path) {
+		return s.Root.Path
+	}
+	return filepath.Join(bundle, s.Root.Path)
+}
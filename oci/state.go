@@ -0,0 +1,19 @@
+package oci
+
+// 容器的 OCI 状态
+const (
+	StateCreating = "creating"
+	StateCreated  = "created"
+	StateRunning  = "running"
+	StateStopped  = "stopped"
+)
+
+// State OCI runtime-spec 中定义的容器状态，hook 从 stdin 读到的就是它
+type State struct {
+	Version     string            `json:"ociVersion"`
+	ID          string            `json:"id"`
+	Status      string            `json:"status"`
+	Pid         int               `json:"pid,omitempty"`
+	Bundle      string            `json:"bundle"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"mydocker/constant"
+	"mydocker/container"
+	"mydocker/network"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+)
+
+// pod 信息存放的目录，和容器信息放在一起
+const podDirName = "pod"
+
+var podInfoLocFormat = container.InfoLoc + podDirName + "/%s/"
+
+// PodInfo pod 的信息
+/*
+	pod 中的容器共享 network、IPC 和 UTS namespace，它们由 pause 进程持有，
+	网络和端口映射都配置在 pause 进程的 network namespace 上，对 pod 中的所有容器生效。
+*/
+type PodInfo struct {
+	Id          string   `json:"id"`
+	Name        string   `json:"name"`
+	Pid         string   `json:"pid"`      // pause 进程在宿主机上的 PID
+	Status      string   `json:"status"`   // running 或 stopped
+	Hostname    string   `json:"hostname"` // pod 中所有容器的 hostname
+	Network     string   `json:"network,omitempty"`
+	PortMapping []string `json:"portmapping,omitempty"`
+	IP          string   `json:"ip,omitempty"`
+	CreatedTime string   `json:"createTime"`
+}
+
+// PodOptions pod create 命令的参数
+type PodOptions struct {
+	Name        string
+	Hostname    string // 为空时使用 pod 名
+	Network     string
+	PortMapping []string
+}
+
+// createPod 创建 pod 并启动它的 pause 进程
+func createPod(opts *PodOptions) error {
+	// 非 root 用户没有权限进入 pause 进程的 namespace
+	if container.Rootless() {
+		return errors.New("pod is not supported in rootless mode")
+	}
+	if _, err := getPodInfoByName(opts.Name); err == nil {
+		return fmt.Errorf("pod %s already exists", opts.Name)
+	}
+	hostname := opts.Hostname
+	if hostname == "" {
+		hostname = opts.Name
+	}
+	pod := &PodInfo{
+		Id:          randStringBytes(container.IDLength),
+		Name:        opts.Name,
+		Hostname:    hostname,
+		Network:     opts.Network,
+		PortMapping: opts.PortMapping,
+		CreatedTime: time.Now().Format("2006-01-02 15:04:05"),
+	}
+	if err := os.MkdirAll(fmt.Sprintf(podInfoLocFormat, pod.Name), constant.Perm0755); err != nil {
+		return errors.Wrapf(err, "mkdir pod %s dir", pod.Name)
+	}
+	if err := startPause(pod); err != nil {
+		_ = os.RemoveAll(fmt.Sprintf(podInfoLocFormat, pod.Name))
+		return err
+	}
+	return nil
+}
+
+// startPause 启动 pod 的 pause 进程，设置 hostname 和网络之后保存 pod 信息
+func startPause(pod *PodInfo) error {
+	cmd, err := container.NewPauseProcess()
+	if err != nil {
+		return err
+	}
+	if err = cmd.Start(); err != nil {
+		return errors.Wrap(err, "start pause process")
+	}
+	// pause 进程退出时由 init 回收，这里不需要等待
+	pid := cmd.Process.Pid
+	pod.Pid = strconv.Itoa(pid)
+	pod.Status = container.RUNNING
+	if err = setUpPod(pod); err != nil {
+		_ = cmd.Process.Kill()
+		return err
+	}
+	return nil
+}
+
+// setUpPod 在 pause 进程的 namespace 中设置 hostname、启动 lo，然后连接网络
+func setUpPod(pod *PodInfo) error {
+	namespaces := []container.NamespacePath{
+		container.NewNamespacePath(pod.Pid, syscall.CLONE_NEWUTS),
+		container.NewNamespacePath(pod.Pid, syscall.CLONE_NEWNET),
+	}
+	err := container.JoinNamespaces(namespaces, func() error {
+		if err := syscall.Sethostname([]byte(pod.Hostname)); err != nil {
+			return errors.Wrap(err, "set hostname")
+		}
+		// 没有连接网络时 pod 中的容器也要能通过 localhost 互相访问
+		lo, err := netlink.LinkByName("lo")
+		if err != nil {
+			return errors.Wrap(err, "get lo")
+		}
+		return errors.Wrap(netlink.LinkSetUp(lo), "set lo up")
+	})
+	if err != nil {
+		return err
+	}
+	if pod.Network != "" {
+		if err = network.Init(); err != nil {
+			return errors.Wrap(err, "init network")
+		}
+		// pause 进程只需要 Pid、Id 和端口映射就可以连接网络
+		info := &container.Info{Pid: pod.Pid, Id: pod.Id, PortMapping: pod.PortMapping}
+		if err = network.Connect(pod.Network, info); err != nil {
+			return errors.Wrap(err, "connect network")
+		}
+		pod.IP = info.IP
+	}
+	return recordPodInfo(pod)
+}
+
+// startPod 重新启动已经停止的 pod 的 pause 进程
+func startPod(podName string) error {
+	pod, err := getPodInfoByName(podName)
+	if err != nil {
+		return err
+	}
+	if pod.Status == container.RUNNING {
+		return fmt.Errorf("pod %s is already running", podName)
+	}
+	return startPause(pod)
+}
+
+// stopPod 停止 pod 中的所有容器，然后停止 pause 进程
+func stopPod(podName string) error {
+	pod, err := getPodInfoByName(podName)
+	if err != nil {
+		return err
+	}
+	if pod.Status != container.RUNNING {
+		return fmt.Errorf("pod %s is not running", podName)
+	}
+	containers, err := podContainers(podName)
+	if err != nil {
+		return err
+	}
+	for _, c := range containers {
+		if c.Status == container.RUNNING {
+			stopContainer(c.Name)
+		}
+	}
+	pid, _ := strconv.Atoi(pod.Pid)
+	if err = syscall.Kill(pid, syscall.SIGTERM); err != nil && err != syscall.ESRCH {
+		return errors.Wrapf(err, "stop pod %s", podName)
+	}
+	// 释放 pause 进程的 IP 和端口映射，重新启动 pod 时会重新分配
+	disconnectNetwork(&container.Info{Id: pod.Id, IP: pod.IP, Network: pod.Network, PortMapping: pod.PortMapping})
+	pod.Status = container.STOP
+	pod.Pid = ""
+	pod.IP = ""
+	return recordPodInfo(pod)
+}
+
+// removePod 删除已经停止的 pod 和其中的容器
+func removePod(podName string) error {
+	pod, err := getPodInfoByName(podName)
+	if err != nil {
+		return err
+	}
+	if pod.Status != container.STOP {
+		return fmt.Errorf("couldn't remove running pod %s, stop it first", podName)
+	}
+	containers, err := podContainers(podName)
+	if err != nil {
+		return err
+	}
+	for _, c := range containers {
+		if c.Status != container.STOP {
+			return fmt.Errorf("container %s in pod %s is still %s", c.Name, podName, c.Status)
+		}
+	}
+	for _, c := range containers {
+		cleanupContainer(c)
+	}
+	dirPath := fmt.Sprintf(podInfoLocFormat, podName)
+	return errors.Wrapf(os.RemoveAll(dirPath), "remove dir %s", dirPath)
+}
+
+// podContainers 返回属于 pod 的所有容器
+func podContainers(podName string) ([]*container.Info, error) {
+	files, err := os.ReadDir(container.InfoLoc)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read dir %s", container.InfoLoc)
+	}
+	var containers []*container.Info
+	for _, file := range files {
+		if file.Name() == "network" || file.Name() == podDirName {
+			continue
+		}
+		c, err := getContainerInfoByName(file.Name())
+		if err != nil {
+			continue
+		}
+		if c.Pod == podName {
+			containers = append(containers, c)
+		}
+	}
+	return containers, nil
+}
+
+// ListPods 打印所有 pod 的信息
+func ListPods() {
+	podDir := container.InfoLoc + podDirName
+	files, err := os.ReadDir(podDir)
+	if err != nil && !os.IsNotExist(err) {
+		log.Errorf("read dir %s error %v", podDir, err)
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
+	fmt.Fprint(w, "ID\tNAME\tPID\tSTATUS\tIP\tCONTAINERS\tCREATED\n")
+	for _, file := range files {
+		pod, err := getPodInfoByName(file.Name())
+		if err != nil {
+			log.Errorf("get pod info error %v", err)
+			continue
+		}
+		containers, _ := podContainers(pod.Name)
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\t%s\n",
+			pod.Id,
+			pod.Name,
+			pod.Pid,
+			pod.Status,
+			pod.IP,
+			len(containers),
+			pod.CreatedTime)
+	}
+	if err = w.Flush(); err != nil {
+		log.Errorf("Flush error %v", err)
+	}
+}
+
+func recordPodInfo(pod *PodInfo) error {
+	content, err := json.Marshal(pod)
+	if err != nil {
+		return errors.Wrapf(err, "json marshal pod %s", pod.Name)
+	}
+	configFilePath := fmt.Sprintf(podInfoLocFormat, pod.Name) + container.ConfigName
+	return errors.Wrapf(os.WriteFile(configFilePath, content, constant.Perm0644), "write file %s", configFilePath)
+}
+
+func getPodInfoByName(podName string) (*PodInfo, error) {
+	configFilePath := fmt.Sprintf(podInfoLocFormat, podName) + container.ConfigName
+	content, err := os.ReadFile(configFilePath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read file %s", configFilePath)
+	}
+	var pod PodInfo
+	if err = json.Unmarshal(content, &pod); err != nil {
+		return nil, errors.Wrapf(err, "json unmarshal pod %s", podName)
+	}
+	return &pod, nil
+}
@@ -3,11 +3,14 @@ package main
 import (
 	"encoding/json"
 	"fmt"
-	"io"
 	"math/rand"
 	"os"
+	"os/exec"
+	"os/signal"
+	"path"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"mydocker/cgroups"
@@ -15,113 +18,567 @@ import (
 	"mydocker/constant"
 	"mydocker/container"
 	"mydocker/network"
+	"mydocker/oci"
+	"mydocker/seccomp"
 
-	"github.com/creack/pty"
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 )
 
-// Run 执行具体 command
+// RunOptions run 命令的参数
+type RunOptions struct {
+	TTY           bool // -t，为容器分配伪终端
+	Interactive   bool // -i，把标准输入传给容器
+	Detach        bool // -d，后台运行，输出写到容器的日志中
+	CmdList       []string
+	Resource      *subsystems.ResourceConfig
+	CgroupParent  string // --cgroup-parent，容器的 cgroup 为 <CgroupParent>/<容器 ID>
+	CgroupPath    string // bundle 中指定的 cgroupsPath，不为空时不再使用 CgroupParent
+	Volume        string
+	ContainerName string
+	ImageName     string
+	Env           []string // -e 指定的环境变量
+	EnvFiles      []string // --env-file 指定的环境变量文件
+	Network       string
+	PortMapping   []string
+	UserNS        bool             // 在新的 user namespace 中运行，容器 root 映射为宿主机上的普通用户
+	Seccomp       *seccomp.Profile // 为 nil 时不启用 seccomp
+	CapAdd        []string         // 在默认 capability 的基础上增加的 capability
+	CapDrop       []string         // 从默认 capability 中去掉的 capability
+	Privileged    bool             // 拥有全部 capability，不遮住 /proc 中的敏感路径，/sys 可写
+	ReadOnly      bool             // rootfs 只读，/tmp 和 /run 挂载可写的 tmpfs
+	Devices       []string         // --device 指定的设备，格式为 /dev/xyz[:path][:rwm]
+	ShmSize       string           // /dev/shm 的大小，为空时使用默认值
+	UseInit       bool             // 由 mydocker init 作为 PID 1 转发信号并回收僵尸进程
+	User          string           // 运行用户，格式为 user[:group]，在容器的 /etc/passwd 和 /etc/group 中解析
+	GroupAdd      []string         // 附加组
+	Workdir       string           // 工作目录，不存在时自动创建
+	Ulimits       []string         // --ulimit 指定的资源限制，格式为 name=soft[:hard]
+	Sysctls       []string         // --sysctl 指定的内核参数，格式为 key=value
+	Hostname      string           // 为空时使用容器 ID，共享 UTS namespace 时由 setUpNamespaces 设置为它的 hostname
+	Domainname    string           // 容器的 NIS domainname
+	DNS           []string         // 为空时使用宿主机 resolv.conf 中的 nameserver
+	DNSSearch     []string         // 为空时使用宿主机 resolv.conf 中的 search
+	DNSOptions    []string         // 为空时使用宿主机 resolv.conf 中的 options
+	ExtraHosts    []string         // --add-host 指定的 hosts 记录，格式为 host:ip
+	NoNewPrivs    bool             // --security-opt no-new-privileges
+
+	// --ipc、--pid、--uts 的取值为 private、host 或 container:<name>，为空时和 private 一样，
+	// Network 除了网络名之外也可以是 host 或 container:<name>
+	IPC        string
+	PID        string
+	UTS        string
+	Pod        string                    // 加入 pod，共享 pod 的 network、IPC 和 UTS namespace
+	TimeOffset string                    // --time-offset，格式为 monotonic=<d>,boottime=<d>，为空时不创建 time namespace
+	Cloneflags uintptr                   // 由 config.json 中的 namespaces 生成，setUpNamespaces 会去掉共享的 namespace
+	Namespaces []container.NamespacePath // 要加入的已有 namespace
+
+	// 以下字段只有从 OCI bundle 创建容器时才会设置
+	Bundle    string              // bundle 目录
+	Rootfs    string              // bundle 中的 rootfs，不再从镜像创建工作目录
+	Init      *container.InitSpec // 由 config.json 生成的 InitSpec
+	Hooks     *oci.Hooks          // 生命周期 hook
+	WaitStart bool                // create 命令创建的容器，需要等 start 命令才执行用户进程
+}
+
+// defaultCgroupParent 没有指定 --cgroup-parent 时容器 cgroup 的父节点
+const defaultCgroupParent = "mydocker"
+
+// Run 执行具体 command，返回前台容器的退出码
 /*
 	这里的 Start 方法是真正开始执行由 NewParentProcess 构建好的 command 的调用，它首先会 clone 出来一个 namespace 隔离的
 	进程，然后在子进程中，调用 /proc/self/exe，也就是调用自己，发送 init 参数，调用我们写的 init 方法，
 	去初始化容器的一些资源。
 */
-func Run(tty bool, cmdList []string, cfg *subsystems.ResourceConfig, volume, containerName, imageName string, envSlice []string, nw string, portMapping []string) {
+func Run(opts *RunOptions) int {
+	// 在启动容器进程之前就接收信号，配置容器期间 mydocker 不会被信号直接杀死，留下没有清理的容器
+	signals := notifySignals()
+	var stdio *attachment
+	containerInfo, parent, err := createContainer(opts, func(cmd *exec.Cmd) error {
+		// 后台运行的容器不能使用伪终端，否则 run 退出关闭 ptmx 时容器进程会收到 SIGHUP
+		if opts.Detach {
+			return cmd.Start()
+		}
+		var err error
+		stdio, err = startAttached(cmd, opts.TTY, opts.Interactive)
+		return err
+	})
+	if err != nil {
+		if stdio != nil {
+			stdio.Close()
+		}
+		signal.Stop(signals)
+		log.Errorf("Create container error %v", err)
+		return 1
+	}
+	// 配置容器期间被中断时不再运行容器，杀掉容器进程并清理
+	if sig, ok := interrupted(signals); ok {
+		signal.Stop(signals)
+		log.Errorf("Interrupted by %v while setting up container %s", sig, containerInfo.Name)
+		abortContainer(parent, containerInfo)
+		if stdio != nil {
+			stdio.Close()
+		}
+		return 128 + int(sig.(syscall.Signal))
+	}
+	if hooks := opts.Hooks; hooks != nil {
+		// poststart 失败不影响容器运行，只打印日志
+		if err = oci.RunHooks(hooks.Poststart, containerState(containerInfo, oci.StateRunning)); err != nil {
+			log.Warnf("Run poststart hooks error %v", err)
+		}
+	}
+	// 后台运行的容器不需要 Wait
+	if opts.Detach {
+		signal.Stop(signals)
+		return 0
+	}
+	stdio.attach(parent.Process.Pid, signals)
+	_ = parent.Wait()
+	// 先恢复终端，后面的日志才能正常换行
+	stdio.Close()
+	deleteContainerInfo(containerInfo.Name)
+	destroyCgroup(containerInfo)
+	disconnectNetwork(containerInfo)
+	network.StopSlirp(containerInfo.SlirpPid)
+	// 从 bundle 创建的容器直接使用 bundle 中的 rootfs，不能删除
+	if opts.Rootfs == "" {
+		_ = container.DeleteWorkSpace(opts.Volume, containerInfo.Name)
+	}
+	runPoststopHooks(containerInfo)
+	return exitStatus(parent)
+}
+
+// createContainer 创建容器进程，配置好 cgroup、网络并执行 prestart hook 之后，把 InitSpec 发给 init
+/*
+	start 负责真正启动容器进程，run 和 create 命令对标准输入输出的处理方式不同。
+	如果设置了 opts.WaitStart，init 完成所有初始化之后会阻塞在 exec.fifo 上，直到 start 命令打开它才会执行用户进程。
+*/
+func createContainer(opts *RunOptions, start func(cmd *exec.Cmd) error) (*container.Info, *exec.Cmd, error) {
+	containerName := opts.ContainerName
 	// 如果没有设置 containerName 则用 containerID 代替
 	containerID := randStringBytes(container.IDLength)
 	if containerName == "" {
 		containerName = containerID
 	}
-	parent, writePipe := container.NewParentProcess(tty, volume, containerName, imageName, envSlice)
-	if parent == nil {
-		log.Errorf("new parent process error")
-		return
+	if err := setUpNamespaces(opts); err != nil {
+		return nil, nil, err
 	}
-	// if err := parent.Start(); err != nil {
-	// 	log.Errorf("Run parent.Start err:%v", err)
-	// }
-	// 创建一个伪终端
-	ptmx, err := pty.Start(parent)
+	spec, err := newInitSpec(opts, containerName, containerID)
 	if err != nil {
-		panic(err)
+		return nil, nil, errors.Wrap(err, "create init spec")
+	}
+	var idMap *container.IDMappings
+	if opts.UserNS {
+		if idMap, err = container.NewIDMappings(); err != nil {
+			return nil, nil, errors.Wrap(err, "create id mappings")
+		}
+		spec.UserNS = true
+		if opts.Rootfs == "" {
+			if container.Rootless() {
+				// rootless 模式下 overlayfs 和 volume 都由 init 在容器的 user namespace 中挂载
+				rootfsMount, volumes := container.RootlessMounts(opts.Volume, containerName)
+				spec.RootfsMount = rootfsMount
+				spec.Mounts = append(spec.Mounts, volumes...)
+			} else {
+				spec.Rootfs = container.UserNSRootfs(containerName)
+			}
+		}
+	}
+	parent, writePipe := container.NewParentProcess(&container.ParentOptions{
+		Detach:        opts.Detach,
+		Volume:        opts.Volume,
+		ContainerName: containerName,
+		ImageName:     opts.ImageName,
+		Rootfs:        opts.Rootfs,
+		Env:           spec.Env,
+		Cloneflags:    opts.Cloneflags,
+		IDMappings:    idMap,
+	})
+	if parent == nil {
+		return nil, nil, errors.New("new parent process error")
+	}
+	status := container.RUNNING
+	if opts.WaitStart {
+		fifo, err := container.CreateExecFifo(containerName)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer fifo.Close()
+		parent.ExtraFiles = append(parent.ExtraFiles, fifo)
+		spec.ExecFifo = true
+		status = container.CREATED
+	}
+	// 容器进程在 clone 时继承要共享的 namespace
+	if err = container.JoinNamespaces(opts.Namespaces, func() error { return start(parent) }); err != nil {
+		return nil, nil, errors.Wrap(err, "start container process")
 	}
-	// 确保在退出前关闭ptmx
-	defer func() { _ = ptmx.Close() }()
-	// 这里只是简单地将伪终端的输出复制到标准输出
-	go func() {
-		_, _ = io.Copy(os.Stdout, ptmx)
-	}()
 
 	// 记录 container 的 info
-	err = recordContainerInfo(parent.Process.Pid, cmdList, containerName, containerID, volume)
+	containerInfo := &container.Info{
+		Pid:         strconv.Itoa(parent.Process.Pid),
+		Id:          containerID,
+		Name:        containerName,
+		Command:     strings.Join(opts.CmdList, ""),
+		Status:      status,
+		Volume:      opts.Volume,
+		PortMapping: opts.PortMapping,
+		Bundle:      opts.Bundle,
+		Hooks:       opts.Hooks,
+		Pod:         opts.Pod,
+		CgroupPath:  containerCgroupPath(opts, containerID),
+
+		Capabilities: spec.Capabilities,
+		Seccomp:      spec.Seccomp,
+		User:         spec.User,
+		GroupAdd:     spec.AdditionalGroups,
+		Workdir:      spec.Cwd,
+		Rlimits:      spec.Rlimits,
+
+		NoNewPrivileges: spec.NoNewPrivileges,
+	}
+	// 非 root 用户需要在 init 读取 InitSpec 之前通过 newuidmap 和 newgidmap 写入 id 映射
+	if idMap != nil && container.Rootless() {
+		if err = container.WriteIDMappings(parent.Process.Pid, idMap); err != nil {
+			abortContainer(parent, containerInfo)
+			return nil, nil, err
+		}
+	}
+	if err = setUpContainer(opts, containerInfo, spec, writePipe); err != nil {
+		abortContainer(parent, containerInfo)
+		return nil, nil, err
+	}
+	return containerInfo, parent, nil
+}
+
+// setUpContainer 在容器进程启动之后、用户进程执行之前完成的配置
+func setUpContainer(opts *RunOptions, containerInfo *container.Info, spec *container.InitSpec, writePipe *os.File) error {
+	err := recordContainerInfo(containerInfo)
 	if err != nil {
-		log.Errorf("Record container info error %v", err)
-		return
+		return errors.Wrap(err, "record container info")
 	}
 
 	// 创建 cgroup manager, 并通过调用 Set 和 Apply 设置资源限制并使限制在容器上生效
-	cgroupManager := cgroups.NewCgroupManager("mydocker-cgroup")
-	defer cgroupManager.Destroy()
-	_ = cgroupManager.Set(cfg)
-	_ = cgroupManager.Apply(parent.Process.Pid, cfg)
+	// rootless 模式下只有 cgroup 被委派给当前用户时才能设置，否则只打印警告，容器不受资源限制
+	cgroupManager := cgroups.NewCgroupManager(containerInfo.CgroupPath)
+	pid, _ := strconv.Atoi(containerInfo.Pid)
+	if err = cgroupManager.Set(opts.Resource); err != nil {
+		if !container.Rootless() {
+			return errors.Wrap(err, "set cgroup")
+		}
+		log.Warnf("Set cgroup of rootless container error %v", err)
+	}
+	if err = cgroupManager.Apply(pid, opts.Resource); err != nil {
+		if !container.Rootless() {
+			return errors.Wrap(err, "apply cgroup")
+		}
+		log.Warnf("Apply cgroup of rootless container error %v", err)
+	}
 
-	if nw != "" {
+	// 和宿主机或其他容器共享 network namespace 时不需要配置网络
+	ownNetwork := opts.Cloneflags&syscall.CLONE_NEWNET != 0
+	if ownNetwork && container.Rootless() {
+		// 非 root 用户无法创建 veth，使用 slirp4netns 在用户态提供网络
+		if containerInfo.SlirpPid, err = network.StartSlirp(pid, nil); err != nil {
+			return errors.Wrap(err, "start rootless network")
+		}
+		if err = updateContainerInfo(containerInfo); err != nil {
+			return errors.Wrap(err, "record container info")
+		}
+	} else if nw := opts.Network; ownNetwork && nw != "" {
 		// config container network
 		network.Init()
-		containerInfo := &container.Info{
-			Id:          containerID,
-			Pid:         strconv.Itoa(parent.Process.Pid),
-			Name:        containerName,
-			PortMapping: portMapping,
-		}
 		if err = network.Connect(nw, containerInfo); err != nil {
-			log.Errorf("Error Connect Network %v", err)
-			return
+			return errors.Wrap(err, "connect network")
+		}
+		if err = updateContainerInfo(containerInfo); err != nil {
+			return errors.Wrap(err, "record container info")
+		}
+	}
+	// bundle 中的容器自己管理 /etc，不生成这些文件
+	if opts.Bundle == "" {
+		if err = writeEtcFiles(opts, containerInfo, spec); err != nil {
+			return err
+		}
+	}
+
+	// namespace 已经创建好了，在用户进程启动之前执行 prestart 和 createRuntime hook
+	if hooks := opts.Hooks; hooks != nil {
+		state := containerState(containerInfo, oci.StateCreating)
+		if err = oci.RunHooks(append(hooks.Prestart, hooks.CreateRuntime...), state); err != nil {
+			return errors.Wrap(err, "run prestart hooks")
 		}
 	}
 
 	// 在子进程创建后才能通过管道来发送参数
-	sendInitCommand(cmdList, writePipe)
-	// 只有设置了 tty 才需要 Wait
-	if tty {
-		_ = parent.Wait()
-		deleteContainerInfo(containerName)
-		_ = container.DeleteWorkSpace(volume, containerName)
-		// containerInfo, _ := getContainerInfoByName(containerName)
-		// err = container.DeleteWorkSpace(containerInfo.Volume, containerName)
-		// if err != nil {
-		// 	log.Errorf("DeleteWorkSpace error %v", err)
-		// }
-	}
-	// 这里就不能在结束后删除了，因为要后台运行
-	// 需要运行完后删除相关目录
-	// rootPath := "/root"
-	// mntPath := "/root/merged"
-	// container.DeleteWorkSpace(rootPath, mntPath, volume)
-}
-
-// sendInitCommand 通过 writePipe 将指令发送给子进程
-func sendInitCommand(cmdList []string, writePipe *os.File) {
-	command := strings.Join(cmdList, " ")
-	log.Infof("command all is: %s", command)
-	_, _ = writePipe.WriteString(command)
-	_ = writePipe.Close()
-}
-
-func recordContainerInfo(containerPID int, cmdList []string, containerName, containerID, volume string) error {
+	return errors.Wrap(sendInitCommand(spec, writePipe), "send init command")
+}
+
+// writeEtcFiles 生成容器的 hosts、resolv.conf 和 hostname，hosts 中需要容器的 IP，所以要在网络配置好之后调用
+func writeEtcFiles(opts *RunOptions, containerInfo *container.Info, spec *container.InitSpec) error {
+	hostname := opts.Hostname
+	if hostname == "" {
+		hostname = containerInfo.Id
+	}
+	cfg := &container.EtcConfig{
+		Hostname:   hostname,
+		Domainname: spec.Domainname,
+		IP:         containerInfo.IP,
+		ExtraHosts: opts.ExtraHosts,
+		DNS:        opts.DNS,
+		DNSSearch:  opts.DNSSearch,
+		DNSOptions: opts.DNSOptions,
+
+		HostNetwork: opts.Network == namespaceHost,
+	}
+	// 共享其他容器或者 pod 的网络时使用它的 IP
+	if name, ok := strings.CutPrefix(opts.Network, namespaceContainerPrefix); ok {
+		target, err := getContainerInfoByName(name)
+		if err != nil {
+			return errors.Wrapf(err, "get container %s info", name)
+		}
+		cfg.IP = target.IP
+	}
+	if opts.Pod != "" {
+		pod, err := getPodInfoByName(opts.Pod)
+		if err != nil {
+			return errors.Wrapf(err, "get pod %s info", opts.Pod)
+		}
+		cfg.IP = pod.IP
+	}
+	// rootless 容器通过 slirp4netns 内置的 DNS 转发访问宿主机的 DNS
+	if containerInfo.SlirpPid != 0 {
+		cfg.IP = network.SlirpIP
+		if len(cfg.DNS) == 0 {
+			cfg.DNS = []string{network.SlirpDNS}
+		}
+	}
+	return errors.Wrap(container.WriteEtcFiles(containerInfo.Name, cfg), "write etc files")
+}
+
+// newInitSpec 生成发送给容器 init 进程的 InitSpec，从 bundle 创建时直接使用 config.json 中的配置
+func newInitSpec(opts *RunOptions, containerName, containerID string) (*container.InitSpec, error) {
+	spec := opts.Init
+	if spec == nil {
+		imageConfig, err := container.GetImageConfig(opts.ImageName)
+		if err != nil {
+			return nil, err
+		}
+		// 默认使用容器 ID 作为 hostname
+		hostname := opts.Hostname
+		if hostname == "" {
+			hostname = containerID
+		}
+		envSlice, err := container.BuildEnv(hostname, opts.TTY, imageConfig.Env, opts.EnvFiles, opts.Env)
+		if err != nil {
+			return nil, err
+		}
+		spec = container.NewInitSpec(containerName, opts.CmdList, envSlice)
+		// 没有自己的 UTS namespace 时 init 不能修改 hostname
+		if opts.Cloneflags&syscall.CLONE_NEWUTS != 0 {
+			spec.Hostname = hostname
+		}
+		spec.Domainname = opts.Domainname
+		for _, h := range opts.ExtraHosts {
+			if _, _, err = container.ParseExtraHost(h); err != nil {
+				return nil, err
+			}
+		}
+		spec.Mounts = append(spec.Mounts, container.EtcMounts(containerName)...)
+		spec.User = opts.User
+		spec.AdditionalGroups = opts.GroupAdd
+		if opts.Workdir != "" {
+			spec.Cwd = opts.Workdir
+		}
+		if spec.Sysctl, err = container.ParseSysctls(opts.Sysctls); err != nil {
+			return nil, err
+		}
+		for _, value := range opts.Ulimits {
+			rlimit, err := container.ParseUlimit(value)
+			if err != nil {
+				return nil, err
+			}
+			spec.Rlimits = append(spec.Rlimits, rlimit)
+		}
+		caps := container.AllCapabilities()
+		if !opts.Privileged {
+			if caps, err = container.MergeCapabilities(container.DefaultCapabilities, opts.CapAdd, opts.CapDrop); err != nil {
+				return nil, err
+			}
+		}
+		spec.Capabilities = container.NewCapabilities(caps)
+		if !opts.Privileged && cgroups.IsCgroup2UnifiedMode() {
+			spec.Capabilities = dropMknod(spec.Capabilities)
+		}
+		spec.Mounts = append(spec.Mounts, container.SysfsMount(!opts.Privileged))
+		if opts.Cloneflags&syscall.CLONE_NEWCGROUP != 0 {
+			cgroupMounts, err := container.CgroupMounts(!opts.Privileged)
+			if err != nil {
+				return nil, err
+			}
+			spec.Mounts = append(spec.Mounts, cgroupMounts...)
+		}
+		if !opts.Privileged {
+			spec.MaskedPaths = container.DefaultMaskedPaths
+			spec.ReadonlyPaths = container.DefaultReadonlyPaths
+		}
+		if opts.ReadOnly {
+			spec.ReadonlyRootfs = true
+			spec.Mounts = append(spec.Mounts, container.ReadonlyRootfsMounts()...)
+		}
+		if err = setUpDevices(opts, spec); err != nil {
+			return nil, err
+		}
+	}
+	// bundle 中的 capability 按 config.json 原样使用，只提示设备没有限制
+	if opts.Init != nil && cgroups.IsCgroup2UnifiedMode() && hasMknod(spec.Capabilities) {
+		log.Warnf("Device access is not limited on cgroup v2, the bundle keeps CAP_MKNOD and can create any device")
+	}
+	spec.Seccomp = opts.Seccomp
+	spec.Init = opts.UseInit
+	spec.NoNewPrivileges = spec.NoNewPrivileges || opts.NoNewPrivs
+	if err := container.CheckSeccomp(spec); err != nil {
+		return nil, err
+	}
+	spec.CgroupNS = opts.Cloneflags&syscall.CLONE_NEWCGROUP != 0
+	if opts.TimeOffset != "" {
+		if err := container.CheckTimeNamespace(opts.UseInit); err != nil {
+			return nil, err
+		}
+		offsets, err := container.ParseTimeOffsets(opts.TimeOffset)
+		if err != nil {
+			return nil, err
+		}
+		spec.TimeOffsets = offsets
+	}
+	for key := range spec.Sysctl {
+		if err := container.ValidateSysctl(key, opts.Cloneflags); err != nil {
+			return nil, err
+		}
+	}
+	return spec, nil
+}
+
+// dropMknod 去掉 CAP_MKNOD，caps 为空时表示保持 init 的所有 capability
+/*
+	cgroup v2 中设备访问需要通过 BPF_CGROUP_DEVICE 类型的 eBPF 程序控制，还没有实现，
+	去掉 CAP_MKNOD 之后容器不能创建设备文件，只能访问私有 /dev 中已有的设备。
+*/
+func dropMknod(caps *container.Capabilities) *container.Capabilities {
+	if caps == nil {
+		caps = container.NewCapabilities(container.AllCapabilities())
+	}
+	return caps.Without("CAP_MKNOD")
+}
+
+// setUpDevices 设置容器 /dev 中的设备和 /dev/shm 的大小，非 privileged 容器只能访问这些设备
+func setUpDevices(opts *RunOptions, spec *container.InitSpec) error {
+	if opts.ShmSize != "" {
+		size, err := container.ParseSize(opts.ShmSize)
+		if err != nil {
+			return errors.Wrap(err, "parse --shm-size")
+		}
+		spec.ShmSize = size
+	}
+	if opts.Privileged {
+		// privileged 容器可以访问宿主机的所有设备，device cgroup 不做限制
+		devices, err := container.HostDevices()
+		if err != nil {
+			return err
+		}
+		spec.Devices = devices
+		return nil
+	}
+	devices := append([]container.Device{}, spec.Devices...)
+	for _, value := range opts.Devices {
+		d, err := container.ParseDevice(value)
+		if err != nil {
+			return err
+		}
+		devices = append(devices, d)
+	}
+	spec.Devices = devices
+	opts.Resource.Devices = container.DeviceCgroupRules(devices)
+	return nil
+}
+
+// containerState 生成容器的 OCI state
+func containerState(info *container.Info, status string) *oci.State {
+	pid, _ := strconv.Atoi(info.Pid)
+	return &oci.State{
+		Version: oci.Version,
+		ID:      info.Name,
+		Status:  status,
+		Pid:     pid,
+		Bundle:  info.Bundle,
+	}
+}
+
+// containerCgroupPath 每个容器使用自己的 cgroup，bundle 中指定了 cgroupsPath 时直接使用
+func containerCgroupPath(opts *RunOptions, containerID string) string {
+	if opts.CgroupPath != "" {
+		return opts.CgroupPath
+	}
+	parent := opts.CgroupParent
+	if parent == "" {
+		parent = defaultCgroupParent
+	}
+	return path.Join(parent, containerID)
+}
+
+// destroyCgroup 删除容器的 cgroup，父节点可能还有其他容器，保留不删
+func destroyCgroup(info *container.Info) {
+	// 旧版本创建的容器共用一个 cgroup，没有记录路径
+	if info.CgroupPath == "" {
+		return
+	}
+	_ = cgroups.NewCgroupManager(info.CgroupPath).Destroy()
+}
+
+// disconnectNetwork 断开容器和网络的连接，释放容器的 IP 和端口映射，失败只打印日志
+func disconnectNetwork(info *container.Info) {
+	if info.Network == "" || info.IP == "" {
+		return
+	}
+	if err := network.Init(); err != nil {
+		log.Errorf("Init network error %v", err)
+		return
+	}
+	if err := network.Disconnect(info.Network, info); err != nil {
+		log.Errorf("Disconnect network %s error %v", info.Network, err)
+	}
+}
+
+// abortContainer 容器进程启动之后的配置失败时杀掉容器进程，删除已经创建的信息目录、cgroup、网络和工作目录
+func abortContainer(cmd *exec.Cmd, info *container.Info) {
+	_ = cmd.Process.Kill()
+	_, _ = cmd.Process.Wait()
+	cleanupContainer(info)
+}
+
+// runPoststopHooks 容器退出并清理之后执行 poststop hook，失败只打印日志
+func runPoststopHooks(info *container.Info) {
+	if info.Hooks == nil {
+		return
+	}
+	if err := oci.RunHooks(info.Hooks.Poststop, containerState(info, oci.StateStopped)); err != nil {
+		log.Warnf("Run poststop hooks error %v", err)
+	}
+}
+
+// sendInitCommand 通过 writePipe 将 InitSpec 发送给子进程
+func sendInitCommand(spec *container.InitSpec, writePipe *os.File) error {
+	defer writePipe.Close()
+	log.Infof("command all is: %q", spec.Args)
+	return container.WriteInitSpec(writePipe, spec)
+}
+
+func recordContainerInfo(containerInfo *container.Info) error {
 	// 生成容器的创建时间
 	createTime := time.Now().Format("2006-01-02 15:04:05")
-	fmt.Println(createTime)
-	command := strings.Join(cmdList, "")
-	// 默认 Status 为 RUNNING
-	containerInfo := &container.Info{
-		Pid:         strconv.Itoa(containerPID),
-		Id:          containerID,
-		Name:        containerName,
-		Command:     command,
-		CreatedTime: createTime,
-		Status:      container.RUNNING,
-		Volume:      volume,
+	// 默认 Status 为 RUNNING，create 命令创建的容器为 CREATED
+	containerInfo.CreatedTime = createTime
+	if containerInfo.Status == "" {
+		containerInfo.Status = container.RUNNING
 	}
 
 	jsonBytes, err := json.Marshal(containerInfo)
@@ -131,8 +588,8 @@ func recordContainerInfo(containerPID int, cmdList []string, containerName, cont
 	}
 	jsonStr := string(jsonBytes)
 	// 容器文件所在的路径
-	dirPath := fmt.Sprintf(container.InfoLocFormat, containerName)
-	if err := os.MkdirAll(dirPath, constant.Perm0622); err != nil {
+	dirPath := fmt.Sprintf(container.InfoLocFormat, containerInfo.Name)
+	if err := os.MkdirAll(dirPath, constant.Perm0755); err != nil {
 		log.Errorf("Mkdir %s error: %v", dirPath, err)
 		return err
 	}
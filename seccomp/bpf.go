@@ -0,0 +1,291 @@
+package seccomp
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// linux/audit.h 和 linux/seccomp.h 中的常量，x/sys/unix 中没有定义
+const (
+	auditArchX86_64  = 0xc000003e
+	auditArchAARCH64 = 0xc00000b7
+	x32SyscallBit    = 0x40000000
+
+	retKillProcess = 0x80000000
+	retKillThread  = 0x00000000
+	retTrap        = 0x00030000
+	retErrno       = 0x00050000
+	retTrace       = 0x7ff00000
+	retLog         = 0x7ffc0000
+	retAllow       = 0x7fff0000
+)
+
+// struct seccomp_data 中各字段的偏移，参数是 64 位的，这里只支持小端
+const (
+	offsetNr   = 0
+	offsetArch = 4
+	offsetArgs = 16
+)
+
+const maxArgs = 6
+
+// Compile 将 profile 编译成 seccomp 使用的 BPF 程序
+/*
+	程序的结构如下：
+	1. 检查 seccomp_data.arch，不是当前架构的系统调用（比如 x86_64 上通过 int 0x80 调用的 32 位系统调用）直接杀死进程
+	2. 每条规则的每个系统调用生成一个块：系统调用号不相等或者参数不匹配时跳到下一个块，否则返回规则的动作
+	3. 所有规则都不匹配时返回 defaultAction
+	规则按照 profile 中的顺序匹配，同一个系统调用有多条带参数的规则时，任意一条匹配即生效。
+	caps 为容器拥有的 capability，用来判断 Docker profile 中 includes.caps 和 excludes.caps 条件。
+*/
+func Compile(p *Profile, caps []string) ([]unix.SockFilter, error) {
+	if nativeArch == 0 {
+		return nil, fmt.Errorf("seccomp is not supported on this architecture")
+	}
+	defaultRet, err := actionRet(p.DefaultAction, p.DefaultErrnoRet)
+	if err != nil {
+		return nil, err
+	}
+	prog := []unix.SockFilter{
+		stmt(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, offsetArch),
+		jump(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, nativeArch, 1, 0),
+		stmt(unix.BPF_RET|unix.BPF_K, retKillProcess),
+	}
+	if nativeArch == auditArchX86_64 {
+		// x32 ABI 的系统调用号带有 x32SyscallBit，不允许使用
+		prog = append(prog,
+			stmt(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, offsetNr),
+			jump(unix.BPF_JMP|unix.BPF_JGE|unix.BPF_K, x32SyscallBit, 0, 1),
+			stmt(unix.BPF_RET|unix.BPF_K, retErrno|uint32(unix.ENOSYS)),
+		)
+	}
+	for _, rule := range p.Syscalls {
+		if !rule.applies(caps) {
+			continue
+		}
+		ret, err := actionRet(rule.Action, rule.ErrnoRet)
+		if err != nil {
+			return nil, err
+		}
+		names := rule.Names
+		if rule.Name != "" {
+			names = append([]string{rule.Name}, names...)
+		}
+		for _, name := range names {
+			nr, ok := syscalls[name]
+			if !ok {
+				// 和 Docker 一样忽略当前架构上不存在的系统调用
+				continue
+			}
+			block, err := syscallBlock(nr, rule.Args, ret)
+			if err != nil {
+				return nil, fmt.Errorf("syscall %s: %v", name, err)
+			}
+			prog = append(prog, block...)
+		}
+	}
+	prog = append(prog, stmt(unix.BPF_RET|unix.BPF_K, defaultRet))
+	if len(prog) > unix.BPF_MAXINSNS {
+		return nil, fmt.Errorf("seccomp profile too large: %d instructions", len(prog))
+	}
+	return prog, nil
+}
+
+// applies 判断规则在当前架构和 capability 下是否生效
+/*
+	与 Docker 一致：includes.caps 中的 capability 必须全部拥有，excludes.caps 中的 capability 一个都不能有。
+*/
+func (s *Syscall) applies(caps []string) bool {
+	if in := s.Includes; in != nil {
+		for _, c := range in.Caps {
+			if !contains(caps, c) {
+				return false
+			}
+		}
+		if len(in.Arches) > 0 && !contains(in.Arches, nativeArchName) {
+			return false
+		}
+	}
+	if ex := s.Excludes; ex != nil {
+		if contains(ex.Arches, nativeArchName) {
+			return false
+		}
+		for _, c := range ex.Caps {
+			if contains(caps, c) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// actionRet 将动作转换为 BPF 的返回值，errno 为空时使用 EPERM
+func actionRet(action Action, errno *uint) (uint32, error) {
+	data := uint32(unix.EPERM)
+	if errno != nil {
+		data = uint32(*errno)
+	}
+	switch action {
+	case ActKill, ActKillThread:
+		return retKillThread, nil
+	case ActKillProcess:
+		return retKillProcess, nil
+	case ActTrap:
+		return retTrap, nil
+	case ActErrno:
+		return retErrno | data&0xffff, nil
+	case ActTrace:
+		return retTrace | data&0xffff, nil
+	case ActAllow:
+		return retAllow, nil
+	case ActLog:
+		return retLog, nil
+	}
+	return 0, fmt.Errorf("unknown seccomp action %q", action)
+}
+
+// block 一段带标签的 BPF 指令，跳转目标在 resolve 时才计算出偏移
+type block struct {
+	insns  []unix.SockFilter
+	jumps  map[int][2]int // 指令下标 -> jt 和 jf 的标签，0 表示不跳转
+	labels map[int]int    // 标签 -> 指令下标
+}
+
+func (b *block) emit(f unix.SockFilter) {
+	b.insns = append(b.insns, f)
+}
+
+func (b *block) emitJump(code uint16, k uint32, jt, jf int) {
+	if b.jumps == nil {
+		b.jumps = map[int][2]int{}
+	}
+	b.jumps[len(b.insns)] = [2]int{jt, jf}
+	b.emit(jump(code, k, 0, 0))
+}
+
+func (b *block) mark(label int) {
+	if b.labels == nil {
+		b.labels = map[int]int{}
+	}
+	b.labels[label] = len(b.insns)
+}
+
+func (b *block) resolve() ([]unix.SockFilter, error) {
+	for i, j := range b.jumps {
+		for n, label := range j {
+			if label == 0 {
+				continue
+			}
+			off := b.labels[label] - i - 1
+			if off < 0 || off > 0xff {
+				return nil, fmt.Errorf("jump offset %d out of range", off)
+			}
+			if n == 0 {
+				b.insns[i].Jt = uint8(off)
+			} else {
+				b.insns[i].Jf = uint8(off)
+			}
+		}
+	}
+	return b.insns, nil
+}
+
+// syscallBlock 生成单个系统调用的匹配块，不匹配时跳到块的末尾
+func syscallBlock(nr int, args []*Arg, ret uint32) ([]unix.SockFilter, error) {
+	const end = 1
+	b := &block{}
+	b.emit(stmt(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, offsetNr))
+	b.emitJump(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, uint32(nr), 0, end)
+	for i, arg := range args {
+		if arg.Index >= maxArgs {
+			return nil, fmt.Errorf("invalid arg index %d", arg.Index)
+		}
+		// 每个条件满足时跳到 next，不满足时跳到块的末尾
+		next := end + 1 + i
+		if err := compareArg(b, arg, next, end); err != nil {
+			return nil, err
+		}
+		b.mark(next)
+	}
+	b.emit(stmt(unix.BPF_RET|unix.BPF_K, ret))
+	b.mark(end)
+	return b.resolve()
+}
+
+// compareArg 生成 64 位参数的比较，BPF 只能做 32 位比较，需要分别比较高 32 位和低 32 位
+func compareArg(b *block, arg *Arg, pass, fail int) error {
+	lo := uint32(offsetArgs + 8*arg.Index)
+	hi := lo + 4
+	ld := func(off uint32) { b.emit(stmt(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, off)) }
+	jeq := func(k uint32, jt, jf int) { b.emitJump(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, k, jt, jf) }
+	jgt := func(k uint32, jt, jf int) { b.emitJump(unix.BPF_JMP|unix.BPF_JGT|unix.BPF_K, k, jt, jf) }
+	jge := func(k uint32, jt, jf int) { b.emitJump(unix.BPF_JMP|unix.BPF_JGE|unix.BPF_K, k, jt, jf) }
+	vhi, vlo := uint32(arg.Value>>32), uint32(arg.Value)
+
+	switch arg.Op {
+	case OpEqualTo:
+		ld(hi)
+		jeq(vhi, 0, fail)
+		ld(lo)
+		jeq(vlo, 0, fail)
+	case OpNotEqual:
+		ld(hi)
+		jeq(vhi, 0, pass)
+		ld(lo)
+		jeq(vlo, fail, 0)
+	case OpMaskedEqual:
+		mhi, mlo := vhi, vlo
+		ehi, elo := uint32(arg.ValueTwo>>32), uint32(arg.ValueTwo)
+		ld(hi)
+		b.emit(stmt(unix.BPF_ALU|unix.BPF_AND|unix.BPF_K, mhi))
+		jeq(ehi, 0, fail)
+		ld(lo)
+		b.emit(stmt(unix.BPF_ALU|unix.BPF_AND|unix.BPF_K, mlo))
+		jeq(elo, 0, fail)
+	case OpGreaterThan:
+		ld(hi)
+		jgt(vhi, pass, 0)
+		jeq(vhi, 0, fail)
+		ld(lo)
+		jgt(vlo, 0, fail)
+	case OpGreaterEqual:
+		ld(hi)
+		jgt(vhi, pass, 0)
+		jeq(vhi, 0, fail)
+		ld(lo)
+		jge(vlo, 0, fail)
+	case OpLessThan:
+		ld(hi)
+		jgt(vhi, fail, 0)
+		jeq(vhi, 0, pass)
+		ld(lo)
+		jge(vlo, fail, 0)
+	case OpLessEqual:
+		ld(hi)
+		jgt(vhi, fail, 0)
+		jeq(vhi, 0, pass)
+		ld(lo)
+		jgt(vlo, fail, 0)
+	default:
+		return fmt.Errorf("unknown seccomp operator %q", arg.Op)
+	}
+	return nil
+}
+
+func stmt(code uint16, k uint32) unix.SockFilter {
+	return unix.SockFilter{Code: code, K: k}
+}
+
+func jump(code uint16, k uint32, jt, jf uint8) unix.SockFilter {
+	return unix.SockFilter{Code: code, Jt: jt, Jf: jf, K: k}
+}
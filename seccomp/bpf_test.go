@@ -0,0 +1,180 @@
+package seccomp
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// run 用一个简单的解释器执行 BPF 程序，只实现了 Compile 会生成的指令
+func run(t *testing.T, prog []unix.SockFilter, nr int, args ...uint64) uint32 {
+	data := make([]byte, offsetArgs+8*maxArgs)
+	binary.LittleEndian.PutUint32(data[offsetNr:], uint32(nr))
+	binary.LittleEndian.PutUint32(data[offsetArch:], nativeArch)
+	for i, a := range args {
+		binary.LittleEndian.PutUint64(data[offsetArgs+8*i:], a)
+	}
+	var acc uint32
+	for pc := 0; pc < len(prog); pc++ {
+		ins := prog[pc]
+		switch ins.Code {
+		case unix.BPF_LD | unix.BPF_W | unix.BPF_ABS:
+			acc = binary.LittleEndian.Uint32(data[ins.K:])
+		case unix.BPF_ALU | unix.BPF_AND | unix.BPF_K:
+			acc &= ins.K
+		case unix.BPF_RET | unix.BPF_K:
+			return ins.K
+		default:
+			var ok bool
+			switch ins.Code {
+			case unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K:
+				ok = acc == ins.K
+			case unix.BPF_JMP | unix.BPF_JGT | unix.BPF_K:
+				ok = acc > ins.K
+			case unix.BPF_JMP | unix.BPF_JGE | unix.BPF_K:
+				ok = acc >= ins.K
+			default:
+				t.Fatalf("unexpected instruction %#v", ins)
+			}
+			if ok {
+				pc += int(ins.Jt)
+			} else {
+				pc += int(ins.Jf)
+			}
+		}
+	}
+	t.Fatal("program does not return")
+	return 0
+}
+
+func TestCompileArgs(t *testing.T) {
+	nr := syscalls["kill"]
+	deny := uint32(retErrno | uint32(unix.EPERM))
+	cases := []struct {
+		op    Operator
+		value uint64
+		arg   uint64
+		match bool
+	}{
+		{OpEqualTo, 1 << 32, 1 << 32, true},
+		{OpEqualTo, 1 << 32, 1, false},
+		{OpNotEqual, 5, 5, false},
+		{OpNotEqual, 5, 1<<32 | 5, true},
+		{OpGreaterThan, 1 << 32, 1<<32 + 1, true},
+		{OpGreaterThan, 1 << 32, 1 << 32, false},
+		{OpGreaterEqual, 1 << 32, 1 << 32, true},
+		{OpGreaterEqual, 1<<32 + 1, 1 << 32, false},
+		{OpLessThan, 1 << 32, 1<<32 - 1, true},
+		{OpLessThan, 1 << 32, 1 << 32, false},
+		{OpLessEqual, 10, 10, true},
+		{OpLessEqual, 10, 1 << 32, false},
+	}
+	for _, c := range cases {
+		prog, err := Compile(&Profile{
+			DefaultAction: ActAllow,
+			Syscalls: []*Syscall{{
+				Names:  []string{"kill"},
+				Action: ActErrno,
+				Args:   []*Arg{{Index: 1, Value: c.value, Op: c.op}},
+			}},
+		}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := uint32(retAllow)
+		if c.match {
+			want = deny
+		}
+		if got := run(t, prog, nr, 0, c.arg); got != want {
+			t.Errorf("%s %#x with arg %#x: got %#x, want %#x", c.op, c.value, c.arg, got, want)
+		}
+	}
+}
+
+func TestDefaultProfile(t *testing.T) {
+	prog, err := Compile(DefaultProfile(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deny := uint32(retErrno | uint32(unix.EPERM))
+	if got := run(t, prog, syscalls["mount"]); got != deny {
+		t.Errorf("mount: got %#x, want %#x", got, deny)
+	}
+	if got := run(t, prog, syscalls["getpid"]); got != retAllow {
+		t.Errorf("getpid: got %#x, want allow", got)
+	}
+	if got := run(t, prog, syscalls["clone"], unix.CLONE_NEWUSER|uint64(unix.SIGCHLD)); got != deny {
+		t.Errorf("clone with CLONE_NEWUSER: got %#x, want %#x", got, deny)
+	}
+	if got := run(t, prog, syscalls["clone"], unix.CLONE_VM|unix.CLONE_THREAD); got != retAllow {
+		t.Errorf("clone thread: got %#x, want allow", got)
+	}
+}
+
+func TestCompileUnknownAction(t *testing.T) {
+	if _, err := Compile(&Profile{DefaultAction: "SCMP_ACT_FOO"}, nil); err == nil {
+		t.Fatal("expect error for unknown action")
+	}
+}
+
+func TestCompileCaps(t *testing.T) {
+	p := &Profile{
+		DefaultAction: ActErrno,
+		Syscalls: []*Syscall{
+			{Names: []string{"reboot"}, Action: ActAllow, Includes: &Filter{Caps: []string{"CAP_SYS_BOOT"}}},
+			{Names: []string{"getpid"}, Action: ActAllow, Excludes: &Filter{Caps: []string{"CAP_SYS_ADMIN"}}},
+		},
+	}
+	deny := uint32(retErrno | uint32(unix.EPERM))
+	cases := []struct {
+		caps   []string
+		reboot uint32
+		getpid uint32
+	}{
+		{nil, deny, retAllow},
+		{[]string{"CAP_SYS_BOOT"}, retAllow, retAllow},
+		{[]string{"CAP_SYS_BOOT", "CAP_SYS_ADMIN"}, retAllow, deny},
+	}
+	for _, c := range cases {
+		prog, err := Compile(p, c.caps)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := run(t, prog, syscalls["reboot"]); got != c.reboot {
+			t.Errorf("reboot with %v: got %#x, want %#x", c.caps, got, c.reboot)
+		}
+		if got := run(t, prog, syscalls["getpid"]); got != c.getpid {
+			t.Errorf("getpid with %v: got %#x, want %#x", c.caps, got, c.getpid)
+		}
+	}
+}
+
+func TestProfileAllows(t *testing.T) {
+	p := &Profile{
+		DefaultAction: ActErrno,
+		Syscalls: []*Syscall{
+			{Names: []string{"execve"}, Action: ActAllow},
+			{Name: "capset", Action: ActErrno},
+			{Name: "capset", Action: ActAllow},
+			{Name: "prctl", Action: ActAllow, Includes: &Filter{Caps: []string{"CAP_SYS_ADMIN"}}},
+		},
+	}
+	cases := []struct {
+		name string
+		want bool
+	}{
+		{"execve", true},
+		{"capset", false},
+		{"prctl", false},
+		{"read", false},
+	}
+	for _, c := range cases {
+		if got := p.Allows(c.name, nil); got != c.want {
+			t.Errorf("Allows(%s) = %v, want %v", c.name, got, c.want)
+		}
+	}
+	if !p.Allows("prctl", []string{"CAP_SYS_ADMIN"}) {
+		t.Error("Allows(prctl) with CAP_SYS_ADMIN expect true")
+	}
+}
@@ -0,0 +1,53 @@
+package seccomp
+
+import "golang.org/x/sys/unix"
+
+// Unconfined --security-opt 中关闭 seccomp 时使用的值
+const Unconfined = "unconfined"
+
+// deniedSyscalls 默认 profile 中禁止的系统调用
+/*
+	这些系统调用要么会影响整个宿主机（加载内核模块、修改时间、重启），
+	要么可以用来逃逸容器或者攻击内核（mount、bpf、keyctl、ptrace 等），容器内的进程一般用不到。
+	mount、pivot_root 等调用在 init 完成初始化之后才被禁止，不影响容器的初始化。
+*/
+var deniedSyscalls = []string{
+	"acct", "add_key", "bpf", "clock_adjtime", "clock_settime", "create_module",
+	"delete_module", "finit_module", "fsconfig", "fsmount", "fsopen", "fspick",
+	"get_kernel_syms", "get_mempolicy", "init_module", "ioperm", "iopl", "kcmp",
+	"kexec_file_load", "kexec_load", "keyctl", "lookup_dcookie", "mbind", "mount",
+	"mount_setattr", "move_mount", "move_pages", "name_to_handle_at", "nfsservctl",
+	"open_by_handle_at", "open_tree", "perf_event_open", "pivot_root", "process_vm_readv",
+	"process_vm_writev", "ptrace", "query_module", "quotactl", "reboot", "request_key",
+	"set_mempolicy", "setns", "settimeofday", "stime", "swapoff", "swapon", "sysfs",
+	"_sysctl", "umount", "umount2", "unshare", "uselib", "userfaultfd", "ustat",
+	"vm86", "vm86old",
+}
+
+// cloneNamespaceFlags clone 时不允许创建新的 namespace
+var cloneNamespaceFlags = []uint64{
+	unix.CLONE_NEWNS, unix.CLONE_NEWUTS, unix.CLONE_NEWIPC, unix.CLONE_NEWUSER,
+	unix.CLONE_NEWPID, unix.CLONE_NEWNET, unix.CLONE_NEWCGROUP,
+}
+
+// DefaultProfile 内置的默认 profile，默认允许，只禁止 deniedSyscalls 中的系统调用
+func DefaultProfile() *Profile {
+	p := &Profile{
+		DefaultAction: ActAllow,
+		Syscalls: []*Syscall{
+			{Names: deniedSyscalls, Action: ActErrno},
+		},
+	}
+	// clone 的 flags 中带有任意一个 namespace 标志时返回 EPERM
+	for _, flag := range cloneNamespaceFlags {
+		p.Syscalls = append(p.Syscalls, &Syscall{
+			Names:  []string{"clone"},
+			Action: ActErrno,
+			Args:   []*Arg{{Index: 0, Value: flag, ValueTwo: flag, Op: OpMaskedEqual}},
+		})
+	}
+	// clone3 的参数在内存中，无法检查 flags，返回 ENOSYS 让 libc 回退到 clone
+	enosys := uint(unix.ENOSYS)
+	p.Syscalls = append(p.Syscalls, &Syscall{Names: []string{"clone3"}, Action: ActErrno, ErrnoRet: &enosys})
+	return p
+}
@@ -0,0 +1,126 @@
+package seccomp
+
+import (
+	"encoding/json"
+	"os"
+
+	"mydocker/oci"
+
+	"github.com/pkg/errors"
+)
+
+// Action 命中规则之后的动作，与 Docker 和 OCI 中的名字一致
+type Action string
+
+const (
+	ActKill        Action = "SCMP_ACT_KILL"
+	ActKillThread  Action = "SCMP_ACT_KILL_THREAD"
+	ActKillProcess Action = "SCMP_ACT_KILL_PROCESS"
+	ActTrap        Action = "SCMP_ACT_TRAP"
+	ActErrno       Action = "SCMP_ACT_ERRNO"
+	ActTrace       Action = "SCMP_ACT_TRACE"
+	ActAllow       Action = "SCMP_ACT_ALLOW"
+	ActLog         Action = "SCMP_ACT_LOG"
+)
+
+// Operator 系统调用参数的比较方式
+type Operator string
+
+const (
+	OpNotEqual     Operator = "SCMP_CMP_NE"
+	OpLessThan     Operator = "SCMP_CMP_LT"
+	OpLessEqual    Operator = "SCMP_CMP_LE"
+	OpEqualTo      Operator = "SCMP_CMP_EQ"
+	OpGreaterEqual Operator = "SCMP_CMP_GE"
+	OpGreaterThan  Operator = "SCMP_CMP_GT"
+	OpMaskedEqual  Operator = "SCMP_CMP_MASKED_EQ"
+)
+
+// Profile seccomp 配置文件，兼容 Docker 的 profile 格式以及 OCI config.json 中的 linux.seccomp
+type Profile struct {
+	DefaultAction   Action     `json:"defaultAction"`
+	DefaultErrnoRet *uint      `json:"defaultErrnoRet,omitempty"`
+	Architectures   []string   `json:"architectures,omitempty"`
+	Syscalls        []*Syscall `json:"syscalls"`
+}
+
+// Syscall 一组系统调用的规则，Name 为老版本 Docker profile 中的写法
+type Syscall struct {
+	Name     string   `json:"name,omitempty"`
+	Names    []string `json:"names,omitempty"`
+	Action   Action   `json:"action"`
+	ErrnoRet *uint    `json:"errnoRet,omitempty"`
+	Args     []*Arg   `json:"args,omitempty"`
+	Includes *Filter  `json:"includes,omitempty"`
+	Excludes *Filter  `json:"excludes,omitempty"`
+}
+
+// Filter Docker profile 中规则生效的条件
+type Filter struct {
+	Arches []string `json:"arches,omitempty"`
+	Caps   []string `json:"caps,omitempty"`
+}
+
+// Arg 参数匹配条件，MASKED_EQ 时 Value 为掩码，ValueTwo 为期望的值
+type Arg struct {
+	Index    uint     `json:"index"`
+	Value    uint64   `json:"value"`
+	ValueTwo uint64   `json:"valueTwo,omitempty"`
+	Op       Operator `json:"op"`
+}
+
+// LoadProfile 读取 Docker 或 OCI 格式的 JSON profile，并检查能否编译成 BPF
+func LoadProfile(path string) (*Profile, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read seccomp profile %s", path)
+	}
+	p := new(Profile)
+	if err = json.Unmarshal(content, p); err != nil {
+		return nil, errors.Wrapf(err, "parse seccomp profile %s", path)
+	}
+	if _, err = Compile(p, nil); err != nil {
+		return nil, errors.Wrapf(err, "invalid seccomp profile %s", path)
+	}
+	return p, nil
+}
+
+// FromOCI 将 config.json 中的 linux.seccomp 转换为 Profile，没有配置时返回 nil
+func FromOCI(s *oci.LinuxSeccomp) (*Profile, error) {
+	if s == nil {
+		return nil, nil
+	}
+	p := &Profile{
+		DefaultAction:   Action(s.DefaultAction),
+		DefaultErrnoRet: s.DefaultErrnoRet,
+		Architectures:   s.Architectures,
+	}
+	for _, sc := range s.Syscalls {
+		rule := &Syscall{Names: sc.Names, Action: Action(sc.Action), ErrnoRet: sc.ErrnoRet}
+		for _, a := range sc.Args {
+			rule.Args = append(rule.Args, &Arg{Index: a.Index, Value: a.Value, ValueTwo: a.ValueTwo, Op: Operator(a.Op)})
+		}
+		p.Syscalls = append(p.Syscalls, rule)
+	}
+	if _, err := Compile(p, nil); err != nil {
+		return nil, errors.Wrap(err, "invalid linux.seccomp")
+	}
+	return p, nil
+}
+
+// Allows 判断 caps 下 profile 是否允许调用 name，和过滤器一样按规则的顺序匹配
+/*
+	带参数条件的规则是否命中要到运行时才知道，这里视为允许。
+*/
+func (p *Profile) Allows(name string, caps []string) bool {
+	for _, rule := range p.Syscalls {
+		if !rule.applies(caps) || (rule.Name != name && !contains(rule.Names, name)) {
+			continue
+		}
+		if len(rule.Args) > 0 {
+			return true
+		}
+		return rule.Action == ActAllow || rule.Action == ActLog
+	}
+	return p.DefaultAction == ActAllow || p.DefaultAction == ActLog
+}
@@ -0,0 +1,47 @@
+package seccomp
+
+import (
+	"fmt"
+	"runtime"
+	"unsafe"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// linux/seccomp.h 中 seccomp 系统调用的参数
+const (
+	setModeFilter   = 1
+	filterFlagTsync = 1
+)
+
+// Install 根据 caps 编译 profile 并为当前进程的所有线程安装 seccomp 过滤器，p 为 nil 时不做任何事
+/*
+	Go 程序是多线程的，这里使用 SECCOMP_FILTER_FLAG_TSYNC 把过滤器同步到所有线程，
+	这样无论之后 exec 在哪个线程上执行，用户进程都会继承这个过滤器。
+	没有 CAP_SYS_ADMIN 时内核要求先设置 no_new_privs。
+*/
+func Install(p *Profile, caps []string) error {
+	if p == nil {
+		return nil
+	}
+	filter, err := Compile(p, caps)
+	if err != nil {
+		return err
+	}
+	prog := unix.SockFprog{
+		Len:    uint16(len(filter)),
+		Filter: &filter[0],
+	}
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	tid, _, errno := unix.RawSyscall(unix.SYS_SECCOMP, setModeFilter, filterFlagTsync, uintptr(unsafe.Pointer(&prog)))
+	if errno != 0 {
+		return errors.Wrap(errno, "install seccomp filter")
+	}
+	// TSYNC 失败时返回无法同步的线程 id
+	if tid != 0 {
+		return fmt.Errorf("install seccomp filter: can not sync thread %d", tid)
+	}
+	return nil
+}
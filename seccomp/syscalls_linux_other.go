@@ -0,0 +1,11 @@
+//go:build linux && !amd64 && !arm64
+
+package seccomp
+
+// 其他架构暂时没有系统调用表，加载 profile 时会报错
+const (
+	nativeArch     = 0
+	nativeArchName = ""
+)
+
+var syscalls = map[string]int{}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"mydocker/seccomp"
+)
+
+// applySecurityOpts 解析 --security-opt，目前支持 seccomp=unconfined、seccomp=<profile 文件> 和 no-new-privileges
+func applySecurityOpts(opts *RunOptions, securityOpts []string) error {
+	for _, opt := range securityOpts {
+		key, value, ok := strings.Cut(opt, "=")
+		// 和 Docker 一样 no-new-privileges 可以不带值，也可以写成 no-new-privileges=true
+		if key == "no-new-privileges" {
+			enabled := true
+			if ok {
+				var err error
+				if enabled, err = strconv.ParseBool(value); err != nil {
+					return fmt.Errorf("invalid --security-opt %s, value should be true or false", opt)
+				}
+			}
+			opts.NoNewPrivs = enabled
+			continue
+		}
+		if !ok {
+			return fmt.Errorf("invalid --security-opt %s, format should be key=value", opt)
+		}
+		switch key {
+		case "seccomp":
+			if value == seccomp.Unconfined {
+				opts.Seccomp = nil
+				continue
+			}
+			profile, err := seccomp.LoadProfile(value)
+			if err != nil {
+				return err
+			}
+			opts.Seccomp = profile
+		default:
+			return fmt.Errorf("unknown --security-opt %s", key)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"mydocker/container"
+	"mydocker/oci"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// containerStatus 根据容器 init 进程是否存活以及 exec.fifo 是否存在得到容器的 OCI 状态
+func containerStatus(info *container.Info) string {
+	pid, err := strconv.Atoi(info.Pid)
+	if err != nil || !processAlive(pid) {
+		return oci.StateStopped
+	}
+	fifoPath := fmt.Sprintf(container.InfoLocFormat, info.Name) + container.ExecFifoName
+	if _, err = os.Stat(fifoPath); err == nil {
+		return oci.StateCreated
+	}
+	return oci.StateRunning
+}
+
+// processAlive 判断进程是否存在，已经退出但还没被回收的僵尸进程也当作已退出
+func processAlive(pid int) bool {
+	if pid <= 0 || syscall.Kill(pid, 0) != nil {
+		return false
+	}
+	// /proc/[pid]/stat 的格式为 pid (comm) state ...，comm 中可能有空格，所以从最后一个 ) 开始解析
+	content, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return false
+	}
+	i := strings.LastIndexByte(string(content), ')')
+	if i < 0 || i+2 >= len(content) {
+		return false
+	}
+	return content[i+2] != 'Z'
+}
+
+// printState 打印容器的 OCI state
+func printState(id string) error {
+	containerInfo, err := getContainerInfoByName(id)
+	if err != nil {
+		return err
+	}
+	content, err := json.MarshalIndent(containerState(containerInfo, containerStatus(containerInfo)), "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "marshal state")
+	}
+	_, err = fmt.Println(string(content))
+	return err
+}
+
+// killContainer 向容器的 init 进程发送信号
+func killContainer(id, signal string) error {
+	sig, err := parseSignal(signal)
+	if err != nil {
+		return err
+	}
+	containerInfo, err := getContainerInfoByName(id)
+	if err != nil {
+		return err
+	}
+	if containerStatus(containerInfo) == oci.StateStopped {
+		return fmt.Errorf("container %s is not running", id)
+	}
+	pid, _ := strconv.Atoi(containerInfo.Pid)
+	return errors.Wrapf(syscall.Kill(pid, sig), "kill container %s", id)
+}
+
+// maxSignal Linux 上最大的实时信号 SIGRTMAX
+const maxSignal = 64
+
+// parseSignal 解析 KILL、SIGKILL 或者 9 这样的信号
+func parseSignal(signal string) (syscall.Signal, error) {
+	if n, err := strconv.Atoi(signal); err == nil {
+		if n <= 0 || n > maxSignal {
+			return 0, fmt.Errorf("invalid signal %s", signal)
+		}
+		return syscall.Signal(n), nil
+	}
+	name := strings.ToUpper(signal)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	sig := unix.SignalNum(name)
+	if sig == 0 {
+		return 0, fmt.Errorf("unknown signal %s", signal)
+	}
+	return sig, nil
+}
+
+const (
+	deleteWaitTimeout  = 10 * time.Second
+	deleteWaitInterval = 100 * time.Millisecond
+)
+
+// deleteContainer 删除已经停止的容器，force 为 true 时先 kill 掉还在运行的容器
+func deleteContainer(id string, force bool) error {
+	containerInfo, err := getContainerInfoByName(id)
+	if err != nil {
+		return err
+	}
+	if containerStatus(containerInfo) != oci.StateStopped {
+		if !force {
+			return fmt.Errorf("cannot delete container %s that is not stopped", id)
+		}
+		pid, _ := strconv.Atoi(containerInfo.Pid)
+		_ = syscall.Kill(pid, syscall.SIGKILL)
+		deadline := time.Now().Add(deleteWaitTimeout)
+		for containerStatus(containerInfo) != oci.StateStopped {
+			if time.Now().After(deadline) {
+				return fmt.Errorf("container %s did not exit after SIGKILL", id)
+			}
+			time.Sleep(deleteWaitInterval)
+		}
+	}
+	cleanupContainer(containerInfo)
+	return nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"mydocker/cgroups"
+	"mydocker/container"
+
+	"github.com/pkg/errors"
+)
+
+// containerStats 打印容器当前的内存和进程数以及它们的限制
+func containerStats(containerNames []string) error {
+	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
+	fmt.Fprint(w, "NAME\tMEM USAGE / LIMIT\tPIDS / LIMIT\n")
+	for _, name := range containerNames {
+		info, err := getContainerInfoByName(name)
+		if err != nil {
+			return errors.Wrapf(err, "get container %s info", name)
+		}
+		if info.Status != container.RUNNING || info.CgroupPath == "" {
+			return fmt.Errorf("container %s is not running or does not have its own cgroup", name)
+		}
+		stats, err := cgroups.NewCgroupManager(info.CgroupPath).GetStats()
+		if err != nil {
+			return errors.Wrapf(err, "get container %s stats", name)
+		}
+		// 没有限制的 subsystem 在 cgroup v1 中不会创建容器的 cgroup，这时打印 -
+		mem, pids := "-", "-"
+		if stats.HasMemory {
+			memLimit := "unlimited"
+			if stats.MemoryLimit > 0 {
+				memLimit = formatBytes(stats.MemoryLimit)
+			}
+			mem = formatBytes(stats.MemoryUsage) + " / " + memLimit
+		}
+		if stats.HasPids {
+			pidsLimit := "unlimited"
+			if stats.PidsLimit > 0 {
+				pidsLimit = strconv.FormatUint(stats.PidsLimit, 10)
+			}
+			pids = strconv.FormatUint(stats.PidsCurrent, 10) + " / " + pidsLimit
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", info.Name, mem, pids)
+	}
+	return errors.Wrap(w.Flush(), "flush")
+}
+
+// formatBytes 按 1024 进制输出 12.5MiB 这样的大小
+func formatBytes(n uint64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	size, i := float64(n), 0
+	for size >= 1024 && i < len(units)-1 {
+		size /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%d%s", n, units[0])
+	}
+	return fmt.Sprintf("%.2f%s", size, units[i])
+}
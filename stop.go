@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"mydocker/constant"
 	"mydocker/container"
+	"mydocker/network"
 	"os"
 	"strconv"
 	"syscall"
@@ -33,16 +34,9 @@ func stopContainer(containerName string) {
 	// 3. 修改容器信息，将容器置为 STOP 状态，并清空 PID
 	containerInfo.Status = container.STOP
 	containerInfo.Pid = ""
-	newContent, err := json.Marshal(containerInfo)
-	if err != nil {
-		log.Errorf("Json marshal %s error %v", containerName, err)
-		return
-	}
 	// 4. 重新存储容器信息
-	dirPath := fmt.Sprintf(container.InfoLocFormat, containerName)
-	configFilePath := dirPath + container.ConfigName
-	if err = os.WriteFile(configFilePath, newContent, constant.Perm0622); err != nil {
-		log.Errorf("Write file %s error: %v", configFilePath, err)
+	if err = updateContainerInfo(containerInfo); err != nil {
+		log.Errorf("Update container %s info error: %v", containerName, err)
 	}
 }
 
@@ -57,14 +51,37 @@ func removeContainer(containerName string) {
 		log.Errorf("Couldn't remove running container")
 		return
 	}
-	dirPath := fmt.Sprintf(container.InfoLocFormat, containerName)
-	if err = os.RemoveAll(dirPath); err != nil {
+	cleanupContainer(containerInfo)
+}
+
+// cleanupContainer 删除容器的信息目录和工作目录，然后执行 poststop hook
+func cleanupContainer(containerInfo *container.Info) {
+	dirPath := fmt.Sprintf(container.InfoLocFormat, containerInfo.Name)
+	if err := os.RemoveAll(dirPath); err != nil {
 		log.Errorf("Remove file %s error: %v", dirPath, err)
 	}
-	err = container.DeleteWorkSpace(containerInfo.Volume, containerName)
+	destroyCgroup(containerInfo)
+	disconnectNetwork(containerInfo)
+	network.StopSlirp(containerInfo.SlirpPid)
+	// 从 bundle 创建的容器使用的是 bundle 中的 rootfs，不需要删除工作目录
+	if containerInfo.Bundle == "" {
+		err := container.DeleteWorkSpace(containerInfo.Volume, containerInfo.Name)
+		if err != nil {
+			log.Errorf("DeleteWorkSpace error %v", err)
+		}
+	}
+	runPoststopHooks(containerInfo)
+}
+
+// updateContainerInfo 重新保存容器信息
+func updateContainerInfo(containerInfo *container.Info) error {
+	newContent, err := json.Marshal(containerInfo)
 	if err != nil {
-		log.Errorf("DeleteWorkSpace error %v", err)
+		return errors.Wrapf(err, "json marshal %s", containerInfo.Name)
 	}
+	dirPath := fmt.Sprintf(container.InfoLocFormat, containerInfo.Name)
+	configFilePath := dirPath + container.ConfigName
+	return errors.Wrapf(os.WriteFile(configFilePath, newContent, constant.Perm0622), "write file %s", configFilePath)
 }
 
 func getContainerInfoByName(containerName string) (*container.Info, error) {
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/creack/pty"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// proxySignals 转发给容器 init 的信号，-it 时 Ctrl-C 等按键由容器内的终端产生，不经过这里
+var proxySignals = []os.Signal{
+	syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGUSR1, syscall.SIGUSR2,
+}
+
+// drainTimeout 容器退出之后等待伪终端中剩余输出的最长时间
+const drainTimeout = time.Second
+
+// attachment 前台运行的容器和宿主机标准输入输出之间的连接
+/*
+	-t 时容器使用一个新的伪终端作为控制终端，-i 时宿主机终端设置为 raw 模式，按键原样发给容器，
+	由容器内伪终端的行规程负责回显以及把 Ctrl-C 转换为 SIGINT，宿主机终端大小变化时同步到伪终端。
+	没有 -t 时容器的 stdout 和 stderr 直接使用宿主机的，-i 时标准输入通过管道传给容器，读到 EOF 后关闭管道。
+	发给 mydocker 的信号都会转发给容器 init，退出时恢复终端设置。
+*/
+type attachment struct {
+	ptmx        *os.File // 只有 -t 时才有
+	interactive bool
+	state       *unix.Termios // 宿主机终端原来的设置，没有设置 raw 模式时为 nil
+	signals     chan os.Signal
+	done        chan struct{} // 伪终端的输出复制完毕
+}
+
+// startAttached 设置好容器的标准输入输出之后启动容器进程
+func startAttached(cmd *exec.Cmd, tty, interactive bool) (*attachment, error) {
+	if tty {
+		return startWithTTY(cmd, interactive)
+	}
+	a := &attachment{interactive: interactive, done: make(chan struct{})}
+	close(a.done)
+	cmd.Stdin = nil
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if !interactive {
+		return a, cmd.Start()
+	}
+	// 不直接把宿主机的标准输入交给容器，宿主机的终端不能被容器里的进程控制
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, errors.Wrap(err, "create stdin pipe")
+	}
+	cmd.Stdin = r
+	err = cmd.Start()
+	r.Close()
+	if err != nil {
+		w.Close()
+		return nil, err
+	}
+	go func() {
+		_, _ = io.Copy(w, os.Stdin)
+		// 关闭写端之后容器里的进程才能读到 EOF
+		w.Close()
+	}()
+	return a, nil
+}
+
+// startWithTTY 创建伪终端并以 slave 端作为控制终端启动容器进程
+func startWithTTY(cmd *exec.Cmd, interactive bool) (*attachment, error) {
+	ptmx, tty, err := pty.Open()
+	if err != nil {
+		return nil, errors.Wrap(err, "open pty")
+	}
+	// 容器启动之前就设置好窗口大小，用户进程一开始就能拿到正确的大小
+	if isTerminal(os.Stdin) {
+		if err = pty.InheritSize(os.Stdin, ptmx); err != nil {
+			log.Warnf("Set pty size error %v", err)
+		}
+	}
+	cmd.Stdin = tty
+	cmd.Stdout = tty
+	cmd.Stderr = tty
+	// Setsid 之后把 fd 0，也就是伪终端的 slave 端设置为容器的控制终端
+	cmd.SysProcAttr.Setctty = true
+	cmd.SysProcAttr.Ctty = 0
+	err = cmd.Start()
+	// 只有容器持有 slave 端，容器退出之后读 master 端才会返回错误
+	tty.Close()
+	if err != nil {
+		ptmx.Close()
+		return nil, err
+	}
+	a := &attachment{ptmx: ptmx, interactive: interactive, done: make(chan struct{})}
+	if interactive {
+		go func() {
+			_, _ = io.Copy(ptmx, os.Stdin)
+		}()
+	}
+	go func() {
+		_, _ = io.Copy(os.Stdout, ptmx)
+		close(a.done)
+	}()
+	return a, nil
+}
+
+// notifySignals 开始接收要转发给容器的信号，容器进程启动之前收到的信号缓存在 channel 中
+func notifySignals() chan os.Signal {
+	signals := make(chan os.Signal, 8)
+	signal.Notify(signals, proxySignals...)
+	return signals
+}
+
+// interrupted 返回配置容器期间收到的信号
+func interrupted(signals chan os.Signal) (os.Signal, bool) {
+	select {
+	case sig := <-signals:
+		return sig, true
+	default:
+		return nil, false
+	}
+}
+
+// attach 开始转发 signals 中的信号，-it 时把宿主机终端设置为 raw 模式并同步窗口大小
+/*
+	raw 模式下 \n 不会再被转换为 \r\n，所以要等 mydocker 自己的日志都打印完之后再调用。
+	只有 -t 没有 -i 时宿主机终端保持原样，Ctrl-C 产生的 SIGINT 由 mydocker 转发给容器。
+*/
+func (a *attachment) attach(pid int, signals chan os.Signal) {
+	a.signals = signals
+	if a.ptmx != nil {
+		signal.Notify(a.signals, syscall.SIGWINCH)
+		if a.interactive && isTerminal(os.Stdin) {
+			state, err := setRawTerminal(int(os.Stdin.Fd()))
+			if err != nil {
+				log.Warnf("Set raw terminal error %v", err)
+			} else {
+				a.state = state
+			}
+		}
+	}
+	go func() {
+		for sig := range a.signals {
+			if sig == syscall.SIGWINCH {
+				_ = pty.InheritSize(os.Stdin, a.ptmx)
+				continue
+			}
+			if err := syscall.Kill(pid, sig.(syscall.Signal)); err != nil {
+				log.Warnf("Forward signal %v to container error %v", sig, err)
+			}
+		}
+	}()
+}
+
+// Close 等待剩余的输出，停止转发信号并恢复宿主机终端
+func (a *attachment) Close() {
+	select {
+	case <-a.done:
+	case <-time.After(drainTimeout):
+	}
+	if a.signals != nil {
+		signal.Stop(a.signals)
+		close(a.signals)
+	}
+	if a.state != nil {
+		if err := unix.IoctlSetTermios(int(os.Stdin.Fd()), unix.TCSETS, a.state); err != nil {
+			log.Warnf("Restore terminal error %v", err)
+		}
+	}
+	if a.ptmx != nil {
+		_ = a.ptmx.Close()
+	}
+}
+
+func isTerminal(f *os.File) bool {
+	_, err := unix.IoctlGetTermios(int(f.Fd()), unix.TCGETS)
+	return err == nil
+}
+
+// setRawTerminal 把终端设置为 raw 模式，与 cfmakeraw 相同，返回原来的设置用于恢复
+func setRawTerminal(fd int) (*unix.Termios, error) {
+	state, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		return nil, errors.Wrap(err, "get termios")
+	}
+	raw := *state
+	raw.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+	raw.Oflag &^= unix.OPOST
+	raw.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+	raw.Cflag &^= unix.CSIZE | unix.PARENB
+	raw.Cflag |= unix.CS8
+	raw.Cc[unix.VMIN] = 1
+	raw.Cc[unix.VTIME] = 0
+	if err = unix.IoctlSetTermios(fd, unix.TCSETS, &raw); err != nil {
+		return nil, errors.Wrap(err, "set raw termios")
+	}
+	return state, nil
+}
+
+// exitStatus 容器 init 的退出码，被信号杀死时和 shell 一样为 128 加上信号编号
+func exitStatus(cmd *exec.Cmd) int {
+	status, ok := cmd.ProcessState.Sys().(syscall.WaitStatus)
+	if !ok {
+		return cmd.ProcessState.ExitCode()
+	}
+	if status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return status.ExitStatus()
+}
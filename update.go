@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"mydocker/cgroups"
+	"mydocker/cgroups/subsystems"
+	"mydocker/container"
+
+	"github.com/pkg/errors"
+)
+
+// updateContainer 修改运行中容器的资源限制，cfg 中没有设置的项保持不变
+func updateContainer(containerName string, cfg *subsystems.ResourceConfig) error {
+	info, err := getContainerInfoByName(containerName)
+	if err != nil {
+		return errors.Wrapf(err, "get container %s info", containerName)
+	}
+	if info.Status != container.RUNNING {
+		return fmt.Errorf("container %s is not running", containerName)
+	}
+	// 旧版本创建的容器共用一个 cgroup，修改会影响其他容器
+	if info.CgroupPath == "" {
+		return fmt.Errorf("container %s does not have its own cgroup", containerName)
+	}
+	cgroupManager := cgroups.NewCgroupManager(info.CgroupPath)
+	if err = cgroupManager.Set(cfg); err != nil {
+		return err
+	}
+	// 之前没有限制的 subsystem 这时才创建容器的 cgroup，要把容器中已有的进程都加进去
+	pid, _ := strconv.Atoi(info.Pid)
+	pids, err := containerPids(pid)
+	if err != nil {
+		return err
+	}
+	for _, p := range pids {
+		if err = cgroupManager.Apply(p, cfg); err != nil {
+			// 查找之后已经退出的进程不用再加入
+			if _, statErr := os.Stat(fmt.Sprintf("/proc/%d", p)); os.IsNotExist(statErr) {
+				continue
+			}
+			return errors.Wrapf(err, "add process %d to cgroup", p)
+		}
+	}
+	return nil
+}
+
+// containerPids 找出容器中的所有进程，包括 exec 启动的进程
+/*
+	pid namespace 可以和宿主机或者其他容器共享，但每个容器都有自己的 mount namespace，
+	所以按 mount namespace 和容器 init 进程相同来查找。
+*/
+func containerPids(initPid int) ([]int, error) {
+	mntNs, err := os.Readlink(fmt.Sprintf("/proc/%d/ns/mnt", initPid))
+	if err != nil {
+		return nil, errors.Wrap(err, "read mount namespace of container")
+	}
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, errors.Wrap(err, "read /proc")
+	}
+	var pids []int
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		// 进程可能已经退出，读不到就跳过
+		if ns, err := os.Readlink(fmt.Sprintf("/proc/%d/ns/mnt", pid)); err == nil && ns == mntNs {
+			pids = append(pids, pid)
+		}
+	}
+	return pids, nil
+}